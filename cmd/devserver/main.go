@@ -0,0 +1,191 @@
+// Command devserver serves a DevAccountService over HTTP, on the
+// paths and with the response envelopes of the custodial, data indexer, alias,
+// SMS and M-Pesa onramp services.
+//
+// Point CUSTODIAL_URL_BASE, DATA_URL_BASE, ALIAS_ENS_BASE, EXTERNAL_SMS_BASE
+// and MPESA_ONRAMP_BASE at the listen address to run HTTPAccountService
+// against it.
+//
+// With -db, state is kept in the given directory across restarts. The replay
+// subcommand then writes the events of the stored accounts and txs to
+// standard output, one JSON encoded event per line:
+//
+//	devserver replay -db state -since 2025-01-01T00:00:00Z
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	fsdb "git.defalsify.org/vise.git/db/fs"
+	"git.defalsify.org/vise.git/logging"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/config"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/dev"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
+	"git.grassecon.net/grassrootseconomics/visedriver/storage"
+	"git.grassecon.net/grassrootseconomics/visedriver/testutil/mocks"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("sarafu-api.devserver")
+)
+
+// fsStorageService keeps the dev service state in a directory.
+type fsStorageService struct {
+	db db.Db
+}
+
+func (fs *fsStorageService) GetUserdataDb(ctx context.Context) (db.Db, error) {
+	return fs.db, nil
+}
+
+// newStorageService returns storage in the directory dir, or in memory if dir is empty.
+func newStorageService(ctx context.Context, dir string) (storage.StorageService, error) {
+	if dir == "" {
+		return mocks.NewMemStorageService(ctx), nil
+	}
+	store := fsdb.NewFsDb()
+	err := store.Connect(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &fsStorageService{db: store}, nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+	serve()
+}
+
+// replay writes the events of the stored state to standard output.
+func replay(args []string) {
+	var dir string
+	var since string
+
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&dir, "db", "", "directory of the stored state")
+	fs.StringVar(&since, "since", "", "only replay events at or after this RFC3339 time")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	if dir == "" {
+		fmt.Fprintf(os.Stderr, "replay needs -db\n")
+		os.Exit(1)
+	}
+	var t time.Time
+	if since != "" {
+		var err error
+		t, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid since time: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	ss, err := newStorageService(ctx, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open state: %v\n", err)
+		os.Exit(1)
+	}
+	svc := dev.NewDevAccountService(ctx, ss)
+	err = svc.Replay(ctx, t, func(ctx context.Context, msg event.Msg) error {
+		b, err := event.JSON.Encode(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the dev server.
+func serve() {
+	var addr string
+	var vouchers string
+	var value int
+	var pool string
+	var dir string
+	var seed string
+
+	flag.StringVar(&addr, "addr", "localhost:5003", "listen address")
+	flag.StringVar(&vouchers, "vouchers", "", "comma-separated symbols of vouchers given to new accounts")
+	flag.IntVar(&value, "value", 500, "amount of each voucher given to new accounts")
+	flag.StringVar(&pool, "pool", "", "symbol of a pool to register with all vouchers")
+	flag.StringVar(&dir, "db", "", "directory to keep state in across restarts, in memory if empty")
+	flag.StringVar(&seed, "seed", "", "YAML or JSON seed file with vouchers, accounts and pools to create in empty state")
+	flag.Parse()
+
+	ctx := context.Background()
+	base := "http://" + addr
+	cfg, err := config.NewConfig(config.Config{
+		CustodialURLBase: base,
+		DataURLBase:      base,
+		AliasEnsURLBase:  base,
+		ExternalSMSBase:  base,
+		MpesaOnrampBase:  base,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid listen address: %v\n", err)
+		os.Exit(1)
+	}
+
+	ss, err := newStorageService(ctx, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open state: %v\n", err)
+		os.Exit(1)
+	}
+	var svc *dev.DevAccountService
+	if seed == "" {
+		svc = dev.NewDevAccountService(ctx, ss)
+	} else {
+		f, err := os.Open(seed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot open seed: %v\n", err)
+			os.Exit(1)
+		}
+		svc, err = dev.NewDevAccountServiceFromSeed(ctx, ss, f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot apply seed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, sym := range strings.Split(vouchers, ",") {
+		sym = strings.TrimSpace(sym)
+		if sym == "" {
+			continue
+		}
+		svc = svc.WithAutoVoucher(ctx, sym, value)
+	}
+	if pool != "" {
+		err = svc.RegisterPool(ctx, pool, pool)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot register pool: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	srv, err := dev.NewServer(svc, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot create server: %v\n", err)
+		os.Exit(1)
+	}
+	logg.Infof("dev server listening", "addr", addr)
+	err = http.ListenAndServe(addr, srv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "server error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -1,11 +1,22 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"net/url"
+	"strings"
 
+	"git.defalsify.org/vise.git/logging"
 	"git.grassecon.net/grassrootseconomics/visedriver/env"
 )
 
+var (
+	logg = logging.NewVanilla().WithDomain("sarafu-api.config")
+)
+
+// ErrMissingToken is returned by Config.CheckTokens for each credential that is not set.
+var ErrMissingToken = errors.New("missing token")
+
 const (
 	createAccountPath            = "/api/v2/account/create"
 	trackStatusPath              = "/api/track"
@@ -69,58 +80,264 @@ var (
 	MpresaOnrampRatesURL      string
 )
 
-func setBase() error {
-	var err error
+// Config holds the upstream base URLs, credentials and flags used to reach
+// the custodial, data indexer, alias, SMS and M-Pesa onramp services, together
+// with the endpoint URLs derived from them.
+//
+// Unlike the package-level variables set by LoadConfig, several Config
+// instances can be used side by side in the same process.
+type Config struct {
+	CustodialURLBase       string
+	DataURLBase            string
+	AliasEnsURLBase        string
+	ExternalSMSBase        string
+	MpesaOnrampBase        string
+	BearerToken            string
+	MpesaOnrampBearerToken string
+	IncludeStablesParam    string
+	// RequireTokens makes a missing bearer token a configuration error instead of a warning.
+	RequireTokens bool
 
-	custodialURLBase = env.GetEnv("CUSTODIAL_URL_BASE", "http://localhost:5003")
-	dataURLBase = env.GetEnv("DATA_URL_BASE", "http://localhost:5006")
-	aliasEnsURLBase = env.GetEnv("ALIAS_ENS_BASE", "http://localhost:5015")
-	externalSMSBase = env.GetEnv("EXTERNAL_SMS_BASE", "http://localhost:5035")
-	BearerToken = env.GetEnv("BEARER_TOKEN", "")
-	IncludeStablesParam = env.GetEnv("INCLUDE_STABLES_PARAM", "false")
-	MpesaOnrampBearerToken = env.GetEnv("MPESA_BEARER_TOKEN", "")
-	mpesaOnrampBase = env.GetEnv("MPESA_ONRAMP_BASE", "https://pretium.v1.grassecon.net")
+	CreateAccountURL          string
+	TrackStatusURL            string
+	BalanceURL                string
+	TrackURL                  string
+	TokenTransferURL          string
+	VoucherHoldingsURL        string
+	VoucherTransfersURL       string
+	VoucherDataURL            string
+	PoolDepositURL            string
+	PoolSwapQuoteURL          string
+	PoolSwapURL               string
+	TopPoolsURL               string
+	RetrievePoolDetailsURL    string
+	PoolSwappableVouchersURL  string
+	SendSMSURL                string
+	AliasRegistrationURL      string
+	AliasResolverURL          string
+	ExternalSMSURL            string
+	AliasUpdateURL            string
+	CreditSendURL             string
+	CreditSendReverseQuoteURL string
+	MpesaOnrampURL            string
+	MpesaOnrampRatesURL       string
+}
 
-	_, err = url.Parse(custodialURLBase)
+// NewConfig creates a Config from the base URLs, credentials and flags set
+// in c, and derives all endpoint URLs from the base URLs.
+func NewConfig(c Config) (*Config, error) {
+	err := c.resolve()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &c, nil
+}
+
+// NewConfigFromEnv creates a Config from the environment.
+func NewConfigFromEnv() (*Config, error) {
+	return NewConfig(Config{
+		CustodialURLBase:       env.GetEnv("CUSTODIAL_URL_BASE", "http://localhost:5003"),
+		DataURLBase:            env.GetEnv("DATA_URL_BASE", "http://localhost:5006"),
+		AliasEnsURLBase:        env.GetEnv("ALIAS_ENS_BASE", "http://localhost:5015"),
+		ExternalSMSBase:        env.GetEnv("EXTERNAL_SMS_BASE", "http://localhost:5035"),
+		BearerToken:            env.GetEnv("BEARER_TOKEN", ""),
+		IncludeStablesParam:    env.GetEnv("INCLUDE_STABLES_PARAM", "false"),
+		MpesaOnrampBearerToken: env.GetEnv("MPESA_BEARER_TOKEN", ""),
+		MpesaOnrampBase:        env.GetEnv("MPESA_ONRAMP_BASE", "https://pretium.v1.grassecon.net"),
+		RequireTokens:          env.GetEnv("REQUIRE_TOKENS", "false") == "true",
+	})
+}
+
+// validateBase checks that a base URL is an absolute http or https URL.
+func validateBase(name string, v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, v, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid %s %q: scheme must be http or https", name, v)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid %s %q: missing host", name, v)
+	}
+	return nil
+}
+
+// Bases returns the base URL of each upstream service, keyed by service name.
+func (c *Config) Bases() map[string]string {
+	return map[string]string{
+		"custodial":    c.CustodialURLBase,
+		"data":         c.DataURLBase,
+		"alias":        c.AliasEnsURLBase,
+		"external_sms": c.ExternalSMSBase,
+		"mpesa_onramp": c.MpesaOnrampBase,
+	}
+}
+
+// CheckTokens returns an error matching ErrMissingToken if any bearer token is empty.
+func (c *Config) CheckTokens() error {
+	var missing []string
+	if c.BearerToken == "" {
+		missing = append(missing, "BEARER_TOKEN")
+	}
+	if c.MpesaOnrampBearerToken == "" {
+		missing = append(missing, "MPESA_BEARER_TOKEN")
 	}
-	_, err = url.Parse(dataURLBase)
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrMissingToken, strings.Join(missing, ", "))
+}
+
+// Validate checks all base URLs, and the bearer tokens if RequireTokens is set.
+//
+// Missing tokens are only logged as a warning when RequireTokens is not set.
+func (c *Config) Validate() error {
+	var errs []error
+	for _, name := range []string{"custodial", "data", "alias", "external_sms", "mpesa_onramp"} {
+		err := validateBase(name+" base url", c.Bases()[name])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := c.CheckTokens()
+	if err != nil {
+		if c.RequireTokens {
+			errs = append(errs, err)
+		} else {
+			logg.Warnf("upstream requests will not be authenticated", "err", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// joinPath joins a base URL and a path, adding any error to errs.
+func joinPath(errs *[]error, base string, elem ...string) string {
+	v, err := url.JoinPath(base, elem...)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("cannot join %q to %q: %v", strings.Join(elem, "/"), base, err))
+	}
+	return v
+}
+
+// resolve validates the configuration and derives the endpoint URLs from the base URLs.
+func (c *Config) resolve() error {
+	var errs []error
+
+	err := c.Validate()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	c.CreateAccountURL = joinPath(&errs, c.CustodialURLBase, createAccountPath)
+	c.TrackStatusURL = joinPath(&errs, c.CustodialURLBase, trackStatusPath)
+	c.BalanceURL = joinPath(&errs, c.CustodialURLBase, balancePathPrefix)
+	c.TrackURL = joinPath(&errs, c.CustodialURLBase, trackPath)
+	c.TokenTransferURL = joinPath(&errs, c.CustodialURLBase, tokenTransferPrefix)
+	c.VoucherHoldingsURL = joinPath(&errs, c.DataURLBase, voucherHoldingsPathPrefix)
+	c.VoucherTransfersURL = joinPath(&errs, c.DataURLBase, voucherTransfersPathPrefix)
+	c.VoucherDataURL = joinPath(&errs, c.DataURLBase, voucherDataPathPrefix)
+	c.SendSMSURL = joinPath(&errs, c.DataURLBase, SendSMSPrefix)
+	c.PoolDepositURL = joinPath(&errs, c.CustodialURLBase, poolDepositPrefix)
+	c.PoolSwapQuoteURL = joinPath(&errs, c.CustodialURLBase, poolSwapQoutePrefix)
+	c.PoolSwapURL = joinPath(&errs, c.CustodialURLBase, poolSwapPrefix)
+	c.TopPoolsURL = joinPath(&errs, c.DataURLBase, topPoolsPrefix)
+	c.RetrievePoolDetailsURL = joinPath(&errs, c.DataURLBase, retrievePoolDetailsPrefix)
+	c.PoolSwappableVouchersURL = joinPath(&errs, c.DataURLBase, poolSwappableVouchersPrefix)
+	c.AliasRegistrationURL = joinPath(&errs, c.AliasEnsURLBase, AliasRegistrationPrefix)
+	c.AliasResolverURL = joinPath(&errs, c.AliasEnsURLBase, AliasResolverPrefix)
+	c.ExternalSMSURL = joinPath(&errs, c.ExternalSMSBase, ExternalSMSPrefix)
+	c.AliasUpdateURL = joinPath(&errs, c.AliasEnsURLBase, AliasUpdatePrefix)
+	c.CreditSendURL = joinPath(&errs, c.DataURLBase, CreditSendPrefix)
+	c.CreditSendReverseQuoteURL = joinPath(&errs, c.DataURLBase, CreditSendReverseQuotePrefix)
+	c.MpesaOnrampURL = joinPath(&errs, c.MpesaOnrampBase, MpesaOnrampPath)
+	c.MpesaOnrampRatesURL = joinPath(&errs, c.MpesaOnrampBase, MpesaOnrampRatesPath)
+
+	return errors.Join(errs...)
 }
 
+// apply sets the package-level variables from c.
+func (c *Config) apply() {
+	custodialURLBase = c.CustodialURLBase
+	dataURLBase = c.DataURLBase
+	aliasEnsURLBase = c.AliasEnsURLBase
+	externalSMSBase = c.ExternalSMSBase
+	mpesaOnrampBase = c.MpesaOnrampBase
+	BearerToken = c.BearerToken
+	MpesaOnrampBearerToken = c.MpesaOnrampBearerToken
+	IncludeStablesParam = c.IncludeStablesParam
+
+	CreateAccountURL = c.CreateAccountURL
+	TrackStatusURL = c.TrackStatusURL
+	BalanceURL = c.BalanceURL
+	TrackURL = c.TrackURL
+	TokenTransferURL = c.TokenTransferURL
+	VoucherHoldingsURL = c.VoucherHoldingsURL
+	VoucherTransfersURL = c.VoucherTransfersURL
+	VoucherDataURL = c.VoucherDataURL
+	PoolDepositURL = c.PoolDepositURL
+	PoolSwapQuoteURL = c.PoolSwapQuoteURL
+	PoolSwapURL = c.PoolSwapURL
+	TopPoolsURL = c.TopPoolsURL
+	RetrievePoolDetailsURL = c.RetrievePoolDetailsURL
+	PoolSwappableVouchersURL = c.PoolSwappableVouchersURL
+	SendSMSURL = c.SendSMSURL
+	AliasRegistrationURL = c.AliasRegistrationURL
+	AliasResolverURL = c.AliasResolverURL
+	ExternalSMSURL = c.ExternalSMSURL
+	AliasUpdateURL = c.AliasUpdateURL
+	CreditSendURL = c.CreditSendURL
+	CreditSendReverseQuoteURL = c.CreditSendReverseQuoteURL
+	MpesaOnrampURL = c.MpesaOnrampURL
+	MpresaOnrampRatesURL = c.MpesaOnrampRatesURL
+}
+
+// Current returns a Config holding the current values of the package-level variables.
+//
+// It is the default for consumers that are not given a Config of their own.
+func Current() *Config {
+	return &Config{
+		CustodialURLBase:       custodialURLBase,
+		DataURLBase:            dataURLBase,
+		AliasEnsURLBase:        aliasEnsURLBase,
+		ExternalSMSBase:        externalSMSBase,
+		MpesaOnrampBase:        mpesaOnrampBase,
+		BearerToken:            BearerToken,
+		MpesaOnrampBearerToken: MpesaOnrampBearerToken,
+		IncludeStablesParam:    IncludeStablesParam,
+
+		CreateAccountURL:          CreateAccountURL,
+		TrackStatusURL:            TrackStatusURL,
+		BalanceURL:                BalanceURL,
+		TrackURL:                  TrackURL,
+		TokenTransferURL:          TokenTransferURL,
+		VoucherHoldingsURL:        VoucherHoldingsURL,
+		VoucherTransfersURL:       VoucherTransfersURL,
+		VoucherDataURL:            VoucherDataURL,
+		PoolDepositURL:            PoolDepositURL,
+		PoolSwapQuoteURL:          PoolSwapQuoteURL,
+		PoolSwapURL:               PoolSwapURL,
+		TopPoolsURL:               TopPoolsURL,
+		RetrievePoolDetailsURL:    RetrievePoolDetailsURL,
+		PoolSwappableVouchersURL:  PoolSwappableVouchersURL,
+		SendSMSURL:                SendSMSURL,
+		AliasRegistrationURL:      AliasRegistrationURL,
+		AliasResolverURL:          AliasResolverURL,
+		ExternalSMSURL:            ExternalSMSURL,
+		AliasUpdateURL:            AliasUpdateURL,
+		CreditSendURL:             CreditSendURL,
+		CreditSendReverseQuoteURL: CreditSendReverseQuoteURL,
+		MpesaOnrampURL:            MpesaOnrampURL,
+		MpesaOnrampRatesURL:       MpresaOnrampRatesURL,
+	}
+}
+
+// LoadConfig reads the configuration from the environment into the package-level variables.
 func LoadConfig() error {
-	err := setBase()
+	c, err := NewConfigFromEnv()
 	if err != nil {
 		return err
 	}
-	CreateAccountURL, _ = url.JoinPath(custodialURLBase, createAccountPath)
-	TrackStatusURL, _ = url.JoinPath(custodialURLBase, trackStatusPath)
-	BalanceURL, _ = url.JoinPath(custodialURLBase, balancePathPrefix)
-	TrackURL, _ = url.JoinPath(custodialURLBase, trackPath)
-	TokenTransferURL, _ = url.JoinPath(custodialURLBase, tokenTransferPrefix)
-	VoucherHoldingsURL, _ = url.JoinPath(dataURLBase, voucherHoldingsPathPrefix)
-	VoucherTransfersURL, _ = url.JoinPath(dataURLBase, voucherTransfersPathPrefix)
-	VoucherDataURL, _ = url.JoinPath(dataURLBase, voucherDataPathPrefix)
-	SendSMSURL, _ = url.JoinPath(dataURLBase, SendSMSPrefix)
-	PoolDepositURL, _ = url.JoinPath(custodialURLBase, poolDepositPrefix)
-	PoolSwapQuoteURL, _ = url.JoinPath(custodialURLBase, poolSwapQoutePrefix)
-	PoolSwapURL, _ = url.JoinPath(custodialURLBase, poolSwapPrefix)
-	TopPoolsURL, _ = url.JoinPath(dataURLBase, topPoolsPrefix)
-	RetrievePoolDetailsURL, _ = url.JoinPath(dataURLBase, retrievePoolDetailsPrefix)
-	PoolSwappableVouchersURL, _ = url.JoinPath(dataURLBase, poolSwappableVouchersPrefix)
-	AliasRegistrationURL, _ = url.JoinPath(aliasEnsURLBase, AliasRegistrationPrefix)
-	AliasResolverURL, _ = url.JoinPath(aliasEnsURLBase, AliasResolverPrefix)
-	ExternalSMSURL, _ = url.JoinPath(externalSMSBase, ExternalSMSPrefix)
-	AliasUpdateURL, _ = url.JoinPath(aliasEnsURLBase, AliasUpdatePrefix)
-	CreditSendURL, _ = url.JoinPath(dataURLBase, CreditSendPrefix)
-	CreditSendReverseQuoteURL, _ = url.JoinPath(dataURLBase, CreditSendReverseQuotePrefix)
-	MpesaOnrampURL, _ = url.JoinPath(mpesaOnrampBase, MpesaOnrampPath)
-	MpresaOnrampRatesURL, _ = url.JoinPath(mpesaOnrampBase, MpesaOnrampRatesPath)
-
+	c.apply()
 	return nil
 }
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewConfigValidation(t *testing.T) {
+	c := Config{
+		CustodialURLBase: "http://localhost:5003",
+		DataURLBase:      "http://localhost:5006",
+		AliasEnsURLBase:  "http://localhost:5015",
+		ExternalSMSBase:  "http://localhost:5035",
+		MpesaOnrampBase:  "https://pretium.v1.grassecon.net",
+	}
+	r, err := NewConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.BalanceURL != "http://localhost:5003/api/account" {
+		t.Fatalf("unexpected balance url %s", r.BalanceURL)
+	}
+
+	c.RequireTokens = true
+	_, err = NewConfig(c)
+	if !errors.Is(err, ErrMissingToken) {
+		t.Fatalf("expected missing token error, got %v", err)
+	}
+	c.BearerToken = "foo"
+	c.MpesaOnrampBearerToken = "bar"
+	_, err = NewConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, base := range []string{"", "localhost:5003", "ftp://localhost", "http://"} {
+		c.DataURLBase = base
+		_, err = NewConfig(c)
+		if err == nil {
+			t.Fatalf("expected error for base url '%s'", base)
+		}
+	}
+}
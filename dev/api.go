@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"math/rand"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"git.defalsify.org/vise.git/db"
@@ -17,6 +19,7 @@ import (
 	"git.grassecon.net/grassrootseconomics/common/phone"
 	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
 	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
 	"git.grassecon.net/grassrootseconomics/visedriver/storage"
 	"github.com/gofrs/uuid"
 	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
@@ -47,6 +50,10 @@ type Tx struct {
 	Voucher string    `json: "voucher"`
 	Value   int       `json: "value"`
 	When    time.Time `json: "when"`
+	// Reason is set if the tx failed, in which case no balances were moved.
+	Reason string `json:"reason"`
+	// Mint is set if the sender was not debited.
+	Mint bool `json:"mint"`
 }
 
 func (t *Tx) ToTransferEvent() event.EventTokenTransfer {
@@ -75,7 +82,41 @@ type Account struct {
 	DefaultVoucher string         `json: "defaultVoucher"`
 	Balances       map[string]int `json: "balances"`
 	Alias          string
-	Txs            []string `json: "txs"`
+	Txs            []string  `json: "txs"`
+	Created        time.Time `json:"created"`
+}
+
+// clone returns a copy of the account that can be changed without changing the original.
+func (a Account) clone() Account {
+	if a.Balances != nil {
+		balances := make(map[string]int, len(a.Balances))
+		for k, v := range a.Balances {
+			balances[k] = v
+		}
+		a.Balances = balances
+	}
+	a.Txs = append([]string(nil), a.Txs...)
+	return a
+}
+
+// credit adds value to the balance of the voucher, and makes it the default
+// voucher if the account has none yet.
+func (a *Account) credit(sym string, value int) {
+	if a.Balances == nil {
+		a.Balances = make(map[string]int)
+	}
+	a.Balances[sym] += value
+	if a.DefaultVoucher == "" {
+		a.DefaultVoucher = sym
+	}
+}
+
+// debit subtracts value from the balance of the voucher.
+func (a *Account) debit(sym string, value int) {
+	if a.Balances == nil {
+		a.Balances = make(map[string]int)
+	}
+	a.Balances[sym] -= value
 }
 
 func (a *Account) ToRegistrationEvent() event.EventCustodialRegistration {
@@ -95,14 +136,26 @@ type Voucher struct {
 }
 
 type Pool struct {
-	Name      string            `json: "name"`
-	Symbol    string            `json: "symbol"`
-	Address   string            `json: "address"`
-	Vouchers  []Voucher         `json: "voucher"`
-	PoolLimit map[string]string `json: "poollimit"`
+	Name      string             `json: "name"`
+	Symbol    string             `json: "symbol"`
+	Address   string             `json: "address"`
+	Vouchers  []Voucher          `json: "voucher"`
+	PoolLimit map[string]string  `json: "poollimit"`
+	Fee       int                `json:"fee"`
+	Rates     map[string]float64 `json:"rates"`
 }
 
+// DevAccountService is an AccountService keeping its state in memory and, if
+// given storage, in a db.
+//
+// It is safe for concurrent use. Events are emitted after the service is
+// unlocked, so the emitter may call the service.
 type DevAccountService struct {
+	// outboxMu is held while outbox entries are delivered, so that they are
+	// delivered in order.
+	outboxMu sync.Mutex
+	// mu guards all state below, and the use of db.
+	mu               sync.RWMutex
 	db               db.Db
 	accounts         map[string]Account
 	accountsTrack    map[string]string
@@ -118,6 +171,16 @@ type DevAccountService struct {
 	emitterFunc      event.EmitterFunc
 	pfx              []byte
 	pools            map[string]Pool
+	idempotent       map[string]string
+	poolFee          int
+	confirmDelay     time.Duration
+	failureRate      float64
+	outbox           map[uint64]outboxEntry
+	outboxSeq        uint64
+	outboxBaseDelay  time.Duration
+	outboxMaxDelay   time.Duration
+	outboxSize       int
+	outboxLoaded     []outboxEntry
 }
 
 func NewDevAccountService(ctx context.Context, ss storage.StorageService) *DevAccountService {
@@ -131,7 +194,13 @@ func NewDevAccountService(ctx context.Context, ss storage.StorageService) *DevAc
 		txsTrack:         make(map[string]string),
 		autoVoucherValue: make(map[string]int),
 		pools:            make(map[string]Pool),
+		idempotent:       make(map[string]string),
 		defaultAccount:   zeroAddress,
+		poolFee:          defaultPoolFee,
+		outbox:           make(map[uint64]outboxEntry),
+		outboxBaseDelay:  defaultOutboxBaseDelay,
+		outboxMaxDelay:   defaultOutboxMaxDelay,
+		outboxSize:       defaultOutboxSize,
 		pfx:              []byte("__"),
 	}
 	if ss != nil {
@@ -154,20 +223,78 @@ func NewDevAccountService(ctx context.Context, ss storage.StorageService) *DevAc
 	return svc
 }
 
+// WithEmitter sets the function events are delivered to, and delivers the
+// events recorded before it was set.
 func (das *DevAccountService) WithEmitter(fn event.EmitterFunc) *DevAccountService {
+	das.mu.Lock()
 	das.emitterFunc = fn
+	das.mu.Unlock()
+	das.flushOutbox(context.Background())
 	return das
 }
 
 func (das *DevAccountService) WithPrefix(pfx []byte) *DevAccountService {
+	das.mu.Lock()
+	defer das.mu.Unlock()
 	das.pfx = pfx
 	return das
 }
 
+// emit records an event in the outbox.
+//
+// It is delivered to the emitter by flushOutbox, which the exported methods
+// defer until after the service is unlocked. Failed deliveries are retried on
+// later emits, or with RetryOutbox, and events recorded without an emitter are
+// delivered once one is set.
+func (das *DevAccountService) emit(ctx context.Context, typ string, item any) {
+	err := das.enqueue(ctx, typ, item)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "cannot record event in outbox, dropping", "err", err, "typ", typ)
+	}
+}
+
+// transferEvent converts a tx to a transfer event, resolving the voucher symbol
+// of the tx to its address.
+func (das *DevAccountService) transferEvent(mytx Tx) event.EventTokenTransfer {
+	ev := mytx.ToTransferEvent()
+	ev.VoucherAddress = das.vouchers[mytx.Voucher].Address
+	return ev
+}
+
+// mintEvent converts a tx to a mint event, resolving the voucher symbol of the
+// tx to its address.
+func (das *DevAccountService) mintEvent(mytx Tx) event.EventTokenMint {
+	ev := mytx.ToMintEvent()
+	ev.VoucherAddress = das.vouchers[mytx.Voucher].Address
+	return ev
+}
+
 func (das *DevAccountService) prefixKeyFor(k string, v string) []byte {
 	return append(das.pfx, []byte(k+"_"+v)...)
 }
 
+// replayed returns the tracking id of an earlier write for the idempotency key set on ctx, if any.
+func (das *DevAccountService) replayed(ctx context.Context, op string) (string, bool) {
+	key, ok := remote.IdempotencyKey(ctx)
+	if !ok {
+		return "", false
+	}
+	track, ok := das.idempotent[op+"_"+key]
+	if ok {
+		logg.DebugCtxf(ctx, "replaying idempotent request", "op", op, "key", key, "track", track)
+	}
+	return track, ok
+}
+
+// remember records the tracking id of a write under the idempotency key set on ctx, if any.
+func (das *DevAccountService) remember(ctx context.Context, op string, track string) {
+	key, ok := remote.IdempotencyKey(ctx)
+	if !ok {
+		return
+	}
+	das.idempotent[op+"_"+key] = track
+}
+
 func (das *DevAccountService) loadAccount(ctx context.Context, pubKey string, v []byte) error {
 	var acc Account
 
@@ -226,6 +353,31 @@ func (das *DevAccountService) loadPoolInfo(ctx context.Context, name string, v [
 	return nil
 }
 
+func (das *DevAccountService) loadVoucher(ctx context.Context, sym string, v []byte) error {
+	var voucher Voucher
+
+	err := json.Unmarshal(v, &voucher)
+	if err != nil {
+		return fmt.Errorf("malformed voucher: %v", sym)
+	}
+	das.vouchers[voucher.Symbol] = voucher
+	das.vouchersAddress[voucher.Address] = voucher.Symbol
+	logg.TraceCtxf(ctx, "add voucher", "symbol", voucher.Symbol)
+	return nil
+}
+
+func (das *DevAccountService) loadAutoVoucher(ctx context.Context, sym string, v []byte) error {
+	var value int
+
+	err := json.Unmarshal(v, &value)
+	if err != nil {
+		return fmt.Errorf("malformed auto voucher value: %v", sym)
+	}
+	das.setAutoVoucher(sym, value)
+	logg.TraceCtxf(ctx, "add auto voucher", "symbol", sym, "value", value)
+	return nil
+}
+
 func (das *DevAccountService) loadItem(ctx context.Context, k []byte, v []byte) error {
 	var err error
 	s := string(k)
@@ -244,6 +396,12 @@ func (das *DevAccountService) loadItem(ctx context.Context, k []byte, v []byte)
 		logg.ErrorCtxf(ctx, "loading aliases failed", "error_load_aliases", err)
 	} else if ss[0] == "pool" {
 		err = das.loadPoolInfo(ctx, ss[1], v)
+	} else if ss[0] == "voucher" {
+		err = das.loadVoucher(ctx, ss[1], v)
+	} else if ss[0] == "autovoucher" {
+		err = das.loadAutoVoucher(ctx, ss[1], v)
+	} else if ss[0] == "outbox" {
+		err = das.loadOutboxEntry(ctx, ss[1], v)
 	} else {
 		logg.ErrorCtxf(ctx, "unknown double underscore key", "key", ss[0])
 	}
@@ -251,7 +409,6 @@ func (das *DevAccountService) loadItem(ctx context.Context, k []byte, v []byte)
 }
 
 // TODO: Add connect tx and account
-// TODO: update balance
 func (das *DevAccountService) loadAll(ctx context.Context) error {
 	dumper, err := das.db.Dump(ctx, []byte{})
 	if err != nil {
@@ -271,6 +428,7 @@ func (das *DevAccountService) loadAll(ctx context.Context) error {
 			return err
 		}
 	}
+	das.restoreOutbox(ctx)
 	return das.indexAll(ctx)
 }
 
@@ -290,82 +448,161 @@ func (das *DevAccountService) indexAll(ctx context.Context) error {
 }
 
 func (das *DevAccountService) WithAutoVoucher(ctx context.Context, symbol string, value int) *DevAccountService {
-	err := das.AddVoucher(ctx, symbol)
-	if err != nil {
-		logg.ErrorCtxf(ctx, "cannot add autovoucher %s: %v", symbol, err)
-		return das
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	// the voucher may have been loaded from the db
+	_, ok := das.vouchers[symbol]
+	if !ok {
+		err := das.addVoucher(ctx, Voucher{
+			Name:   symbol,
+			Symbol: symbol,
+		})
+		if err != nil {
+			logg.ErrorCtxf(ctx, "cannot add autovoucher %s: %v", symbol, err)
+			return das
+		}
 	}
-	das.autoVouchers = append(das.autoVouchers, symbol)
-	das.autoVoucherValue[symbol] = value
+	das.setAutoVoucher(symbol, value)
 	return das
 }
 
+// setAutoVoucher sets the amount of the voucher given to new accounts.
+func (das *DevAccountService) setAutoVoucher(symbol string, value int) {
+	_, ok := das.autoVoucherValue[symbol]
+	if !ok {
+		das.autoVouchers = append(das.autoVouchers, symbol)
+	}
+	das.autoVoucherValue[symbol] = value
+}
+
+// RegisterPool creates a pool holding all vouchers, and mints its reserves.
+//
+// If a pool with the symbol is already registered, such as when it was loaded
+// from the db, it is left unchanged.
 func (das *DevAccountService) RegisterPool(ctx context.Context, name string, sm string) error {
-	var seedVouchers []Voucher
+	var vouchers []Voucher
+
+	defer das.flushOutbox(ctx)
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	_, ok := das.pools[poolAddressFor(sm)]
+	if ok {
+		logg.DebugCtxf(ctx, "pool already registered", "symbol", sm)
+		return nil
+	}
+	for _, v := range das.vouchers {
+		vouchers = append(vouchers, v)
+	}
+	_, err := das.registerPool(ctx, name, sm, vouchers)
+	return err
+}
 
+// poolAddressFor returns the address of the pool with the given symbol.
+func poolAddressFor(sm string) string {
 	h := sha1.New()
 	h.Write([]byte(sm))
-	z := h.Sum(nil)
-	pooladdr := fmt.Sprintf("0x%x", z)
+	return fmt.Sprintf("0x%x", h.Sum(nil))
+}
+
+// registerPool creates a pool holding the given vouchers, and mints its reserves.
+func (das *DevAccountService) registerPool(ctx context.Context, name string, sm string, vouchers []Voucher) (Pool, error) {
+	var seedVouchers []Voucher
+
+	pooladdr := poolAddressFor(sm)
 
 	p := Pool{
 		Name:      name,
 		Symbol:    sm,
 		Address:   pooladdr,
 		PoolLimit: make(map[string]string),
+		Fee:       das.poolFee,
+		Rates:     make(map[string]float64),
 	}
 
-	for _, v := range das.vouchers {
+	// the pool reserves are held by an account at the pool address
+	_, ok := das.accounts[pooladdr]
+	if !ok {
+		acc := Account{
+			Address: pooladdr,
+		}
+		err := das.saveAccount(ctx, acc)
+		if err != nil {
+			return p, err
+		}
+		das.accounts[pooladdr] = acc
+	}
+
+	for _, v := range vouchers {
 		//pre-load vouchers with vouchers when a pool is registered
 		seedVouchers = append(seedVouchers, v)
 		p.PoolLimit[v.Address] = fmt.Sprintf("%f", defaultVoucherBalance)
+		mytx, err := das.transfer(ctx, defaultPoolReserve, zeroAddress, pooladdr, v.Address, true, "")
+		if err != nil {
+			return p, err
+		}
+		das.emit(ctx, event.EventTokenMintTag, das.mintEvent(mytx))
 	}
 	p.Vouchers = append(p.Vouchers, seedVouchers...)
 
 	err := das.savePoolInfo(ctx, p)
 	if err != nil {
-		return err
+		return p, err
 	}
-	return nil
+	das.pools[pooladdr] = p
+	return p, nil
 }
 
-// TODO: add persistence for vouchers
 // TODO: set max balance for 0x00 address
 func (das *DevAccountService) AddVoucher(ctx context.Context, symbol string) error {
-	if symbol == "" {
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	return das.addVoucher(ctx, Voucher{
+		Name:   symbol,
+		Symbol: symbol,
+	})
+}
+
+// addVoucher adds and stores a voucher. If the voucher has no address, one is
+// derived from its symbol.
+func (das *DevAccountService) addVoucher(ctx context.Context, v Voucher) error {
+	if v.Symbol == "" {
 		return fmt.Errorf("cannot add empty sym voucher")
 	}
-	v, ok := das.vouchers[symbol]
+	_, ok := das.vouchers[v.Symbol]
 	if ok {
 		return fmt.Errorf("already have voucher with symbol %s", v.Symbol)
 	}
-	h := sha1.New()
-	h.Write([]byte(symbol))
-	z := h.Sum(nil)
-	address := fmt.Sprintf("0x%x", z)
-	das.vouchers[symbol] = Voucher{
-		Name:    symbol,
-		Symbol:  symbol,
-		Address: address,
-	}
-	das.vouchersAddress[address] = symbol
-	logg.InfoCtxf(ctx, "added dev voucher", "symbol", symbol, "address", address)
+	if v.Address == "" {
+		h := sha1.New()
+		h.Write([]byte(v.Symbol))
+		z := h.Sum(nil)
+		v.Address = fmt.Sprintf("0x%x", z)
+	}
+	err := das.saveVoucher(ctx, v)
+	if err != nil {
+		return err
+	}
+	das.vouchers[v.Symbol] = v
+	das.vouchersAddress[v.Address] = v.Symbol
+	logg.InfoCtxf(ctx, "added dev voucher", "symbol", v.Symbol, "address", v.Address)
 	return nil
 }
 
 // AccountService implementation below
 
 func (das *DevAccountService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	acc, ok := das.accounts[publicKey]
 	if !ok {
-		return nil, fmt.Errorf("account not found (publickey): %v", publicKey)
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", publicKey)
 	}
 	if acc.DefaultVoucher == "" {
-		return nil, fmt.Errorf("no default voucher set for: %v", publicKey)
+		return nil, remote.NewError(remote.ErrNotFound, "no default voucher set for: %v", publicKey)
 	}
 	bal, ok := acc.Balances[acc.DefaultVoucher]
 	if !ok {
-		return nil, fmt.Errorf("balance not found for default token %s pubkey %v", acc.DefaultVoucher, publicKey)
+		return nil, remote.NewError(remote.ErrNotFound, "balance not found for default token %s pubkey %v", acc.DefaultVoucher, publicKey)
 	}
 	return &models.BalanceResult{
 		Balance: strconv.Itoa(bal),
@@ -373,6 +610,7 @@ func (das *DevAccountService) CheckBalance(ctx context.Context, publicKey string
 	}, nil
 }
 
+// balanceAuto funds a new account with the auto vouchers, minted from the default account.
 func (das *DevAccountService) balanceAuto(ctx context.Context, pubKey string) error {
 	for _, v := range das.autoVouchers {
 		voucher, ok := das.vouchers[v]
@@ -383,16 +621,23 @@ func (das *DevAccountService) balanceAuto(ctx context.Context, pubKey string) er
 		if !ok {
 			value = 0
 		}
-		_, err := das.TokenTransfer(ctx, strconv.Itoa(value), das.defaultAccount, pubKey, voucher.Address)
+		mytx, err := das.transfer(ctx, value, das.defaultAccount, pubKey, voucher.Address, true, "")
 		if err != nil {
 			return err
 		}
+		das.emit(ctx, event.EventTokenMintTag, das.mintEvent(mytx))
 	}
 	return nil
 }
 
 func (das *DevAccountService) GetAliases(ctx context.Context) map[string]string {
-	return das.accountsAlias
+	das.mu.RLock()
+	defer das.mu.RUnlock()
+	aliases := make(map[string]string, len(das.accountsAlias))
+	for k, v := range das.accountsAlias {
+		aliases[k] = v
+	}
+	return aliases
 }
 
 func (das *DevAccountService) saveAccount(ctx context.Context, acc Account) error {
@@ -409,6 +654,34 @@ func (das *DevAccountService) saveAccount(ctx context.Context, acc Account) erro
 	return das.db.Put(ctx, []byte(k), v)
 }
 
+func (das *DevAccountService) saveVoucher(ctx context.Context, v Voucher) error {
+	if das.db == nil {
+		return nil
+	}
+	k := das.prefixKeyFor("voucher", v.Symbol)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	das.db.SetSession("")
+	das.db.SetPrefix(db.DATATYPE_USERDATA)
+	return das.db.Put(ctx, []byte(k), b)
+}
+
+func (das *DevAccountService) saveAutoVoucher(ctx context.Context, sym string, value int) error {
+	if das.db == nil {
+		return nil
+	}
+	k := das.prefixKeyFor("autovoucher", sym)
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	das.db.SetSession("")
+	das.db.SetPrefix(db.DATATYPE_USERDATA)
+	return das.db.Put(ctx, []byte(k), b)
+}
+
 func (das *DevAccountService) savePoolInfo(ctx context.Context, pool Pool) error {
 	if das.db == nil {
 		return nil
@@ -441,11 +714,15 @@ func (das *DevAccountService) saveAlias(ctx context.Context, alias map[string]st
 }
 
 func (das *DevAccountService) CreateAccount(ctx context.Context) (*models.AccountResult, error) {
+	defer das.flushOutbox(ctx)
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	return das.newAccount(ctx)
+}
+
+// newAccount creates an account with a random address.
+func (das *DevAccountService) newAccount(ctx context.Context) (*models.AccountResult, error) {
 	var b [pubKeyLen]byte
-	uid, err := uuid.NewV4()
-	if err != nil {
-		return nil, err
-	}
 	c, err := rand.Read(b[:])
 	if err != nil {
 		return nil, err
@@ -454,9 +731,19 @@ func (das *DevAccountService) CreateAccount(ctx context.Context) (*models.Accoun
 		return nil, fmt.Errorf("short read: %d", c)
 	}
 	pubKey := fmt.Sprintf("0x%x", b)
+	return das.createAccount(ctx, pubKey)
+}
+
+// createAccount creates an account with the given address, and funds it with the auto vouchers.
+func (das *DevAccountService) createAccount(ctx context.Context, pubKey string) (*models.AccountResult, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
 	acc := Account{
 		Track:   uid.String(),
 		Address: pubKey,
+		Created: time.Now(),
 	}
 
 	err = das.saveAccount(ctx, acc)
@@ -466,6 +753,7 @@ func (das *DevAccountService) CreateAccount(ctx context.Context) (*models.Accoun
 
 	das.accounts[pubKey] = acc
 	das.accountsTrack[uid.String()] = pubKey
+	das.emit(ctx, event.EventRegistrationTag, acc.ToRegistrationEvent())
 	err = das.balanceAuto(ctx, pubKey)
 	if err != nil {
 		return nil, err
@@ -475,16 +763,6 @@ func (das *DevAccountService) CreateAccount(ctx context.Context) (*models.Accoun
 		das.defaultAccount = pubKey
 	}
 
-	if das.emitterFunc != nil {
-		msg := event.Msg{
-			Typ:  event.EventRegistrationTag,
-			Item: acc,
-		}
-		err = das.emitterFunc(ctx, msg)
-		if err != nil {
-			logg.ErrorCtxf(ctx, "emitter returned error", "err", err, "msg", msg)
-		}
-	}
 	logg.TraceCtxf(ctx, "account created", "account", acc)
 
 	return &models.AccountResult{
@@ -494,84 +772,154 @@ func (das *DevAccountService) CreateAccount(ctx context.Context) (*models.Accoun
 }
 
 func (das *DevAccountService) PoolDeposit(ctx context.Context, amount, from, poolAddress, tokenAddress string) (*models.PoolDepositResult, error) {
+	defer das.flushOutbox(ctx)
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	if track, ok := das.replayed(ctx, remote.MethodPoolDeposit); ok {
+		return &models.PoolDepositResult{
+			TrackingId: track,
+		}, nil
+	}
 	_, ok := das.accounts[from]
 	if !ok {
-		return nil, fmt.Errorf("account not found (publickey): %v", from)
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", from)
 	}
-	sym, ok := das.vouchersAddress[tokenAddress]
+	p, ok := das.pools[poolAddress]
 	if !ok {
-		return nil, fmt.Errorf("voucher address %v not found", tokenAddress)
-	}
-	uid, err := uuid.NewV4()
-	if err != nil {
-		return nil, err
+		return nil, remote.NewError(remote.ErrNotFound, "pool address %v not found", poolAddress)
 	}
-
-	_, ok = das.vouchers[sym]
+	ok = p.hasVoucher(tokenAddress)
 	if !ok {
-		return nil, fmt.Errorf("voucher address %v found but does not resolve", tokenAddress)
+		return nil, remote.NewError(remote.ErrValidation, "voucher with address %v not found in the pool", tokenAddress)
+	}
+	value, err := strconv.Atoi(amount)
+	if err != nil {
+		return nil, remote.NewError(remote.ErrValidation, "invalid amount %s: %v", amount, err)
 	}
 
+	mytx, err := das.transfer(ctx, value, from, p.Address, tokenAddress, false, das.failure())
 	if err != nil {
 		return nil, err
 	}
+	if mytx.Reason == "" {
+		das.emit(ctx, event.EventPoolDepositTag, event.EventPoolDeposit{
+			From:           from,
+			PoolAddress:    p.Address,
+			VoucherAddress: tokenAddress,
+			Value:          value,
+			TxHash:         mytx.Hsh,
+		})
+	}
+	das.remember(ctx, remote.MethodPoolDeposit, mytx.Track)
 	return &models.PoolDepositResult{
-		TrackingId: uid.String(),
+		TrackingId: mytx.Track,
 	}, nil
 }
 
 func (das *DevAccountService) GetPoolSwapQuote(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapQuoteResult, error) {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	_, ok := das.accounts[from]
 	if !ok {
-		return nil, fmt.Errorf("account not found (publickey): %v", from)
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", from)
 	}
 	p, ok := das.pools[poolAddress]
 	if !ok {
-		return nil, fmt.Errorf("pool address %v not found", poolAddress)
+		return nil, remote.NewError(remote.ErrNotFound, "pool address %v not found", poolAddress)
 	}
-
-	//resolve the token address you are trying to swap from(fromTokenAddress)
-	ok = p.hasVoucher(fromTokenAddress)
-	if !ok {
-		return nil, fmt.Errorf("voucher with address %v not found in the pool", fromTokenAddress)
+	value, err := strconv.Atoi(amount)
+	if err != nil {
+		return nil, remote.NewError(remote.ErrValidation, "invalid amount %s: %v", amount, err)
 	}
 
-	//Return a a quote that is equal to the amount entered
-	return &models.PoolSwapQuoteResult{IncludesFeesDeduction: false, OutValue: amount}, nil
+	out, err := das.quote(p, value, fromTokenAddress, toTokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PoolSwapQuoteResult{IncludesFeesDeduction: p.Fee > 0, OutValue: strconv.Itoa(out)}, nil
 }
 
 func (das *DevAccountService) PoolSwap(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapResult, error) {
-	uid, err := uuid.NewV4()
-	if err != nil {
-		return nil, err
+	defer das.flushOutbox(ctx)
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	if track, ok := das.replayed(ctx, remote.MethodPoolSwap); ok {
+		return &models.PoolSwapResult{TrackingId: track}, nil
 	}
 
 	p, ok := das.pools[poolAddress]
 	if !ok {
-		return nil, fmt.Errorf("pool address %v not found", toTokenAddress)
+		return nil, remote.NewError(remote.ErrNotFound, "pool address %v not found", poolAddress)
 	}
-	_, ok = das.accounts[from]
+	acc, ok := das.accounts[from]
 	if !ok {
-		return nil, fmt.Errorf("account not found (publickey): %v", from)
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", from)
 	}
-	ok = p.hasVoucher(fromTokenAddress)
-	if !ok {
-		return nil, fmt.Errorf("token %v not found in the pool", fromTokenAddress)
+	value, err := strconv.Atoi(amount)
+	if err != nil {
+		return nil, remote.NewError(remote.ErrValidation, "invalid amount %s: %v", amount, err)
+	}
+	limit, err := p.limit(fromTokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	if value > limit {
+		return nil, remote.NewError(remote.ErrValidation, "amount %d exceeds pool limit %d for %v", value, limit, fromTokenAddress)
+	}
+	out, err := das.quote(p, value, fromTokenAddress, toTokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	if out == 0 {
+		return nil, remote.NewError(remote.ErrValidation, "amount %d too small to swap", value)
+	}
+	sym := das.vouchersAddress[fromTokenAddress]
+	if acc.Balances[sym] < value {
+		return nil, remote.NewError(remote.ErrInsufficientBalance, "insufficient balance of %s for %v: have %d, need %d", sym, from, acc.Balances[sym], value)
 	}
 
-	ok = p.hasVoucher(toTokenAddress)
+	// both legs of the swap apply or neither does, so the accounts are kept
+	// to be restored if the second fails
+	prevPool, ok := das.accounts[p.Address]
 	if !ok {
-		return nil, fmt.Errorf("token %v not found in the pool", toTokenAddress)
+		prevPool = Account{
+			Address: p.Address,
+		}
 	}
-
-	return &models.PoolSwapResult{TrackingId: uid.String()}, nil
+	prev := []Account{acc.clone(), prevPool.clone()}
+	reason := das.failure()
+	mytx, err := das.transfer(ctx, value, from, p.Address, fromTokenAddress, false, reason)
+	if err != nil {
+		return nil, err
+	}
+	_, err = das.transfer(ctx, out, p.Address, from, toTokenAddress, false, reason)
+	if err != nil {
+		das.revert(ctx, mytx, err, prev...)
+		return nil, err
+	}
+	if reason == "" {
+		das.emit(ctx, event.EventPoolSwapTag, event.EventPoolSwap{
+			From:               from,
+			PoolAddress:        p.Address,
+			FromVoucherAddress: fromTokenAddress,
+			ToVoucherAddress:   toTokenAddress,
+			InValue:            value,
+			OutValue:           out,
+			TxHash:             mytx.Hsh,
+		})
+	}
+	das.remember(ctx, remote.MethodPoolSwap, mytx.Track)
+	return &models.PoolSwapResult{TrackingId: mytx.Track}, nil
 }
 
 func (das *DevAccountService) TrackAccountStatus(ctx context.Context, publicKey string) (*models.TrackStatusResult, error) {
 	var ok bool
+
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	_, ok = das.accounts[publicKey]
 	if !ok {
-		return nil, fmt.Errorf("account not found (publickey): %v", publicKey)
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", publicKey)
 	}
 	return &models.TrackStatusResult{
 		Active: true,
@@ -580,17 +928,28 @@ func (das *DevAccountService) TrackAccountStatus(ctx context.Context, publicKey
 
 func (das *DevAccountService) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
 	var holdings []dataserviceapi.TokenHoldings
-	_, ok := das.accounts[publicKey]
+
+	das.mu.RLock()
+	defer das.mu.RUnlock()
+	acc, ok := das.accounts[publicKey]
 	if !ok {
-		return nil, fmt.Errorf("account not found (publickey): %v", publicKey)
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", publicKey)
 	}
-	//TODO: Iterate over the account acc.Balances object
-	for _, voucher := range das.vouchers {
+	syms := make([]string, 0, len(acc.Balances))
+	for sym := range acc.Balances {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		voucher, ok := das.vouchers[sym]
+		if !ok {
+			return nil, remote.NewError(remote.ErrInternal, "voucher %s in balances but not found in voucher list", sym)
+		}
 		holdings = append(holdings, dataserviceapi.TokenHoldings{
 			TokenAddress:  voucher.Address,
 			TokenSymbol:   voucher.Symbol,
 			TokenDecimals: strconv.Itoa(voucher.Decimals),
-			Balance:       strconv.Itoa(int(defaultVoucherBalance)),
+			Balance:       strconv.Itoa(acc.Balances[sym]),
 		})
 	}
 
@@ -599,9 +958,12 @@ func (das *DevAccountService) FetchVouchers(ctx context.Context, publicKey strin
 
 func (das *DevAccountService) FetchTransactions(ctx context.Context, publicKey string) ([]dataserviceapi.Last10TxResponse, error) {
 	var lasttx []dataserviceapi.Last10TxResponse
+
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	acc, ok := das.accounts[publicKey]
 	if !ok {
-		return nil, fmt.Errorf("account not found (publickey): %v", publicKey)
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", publicKey)
 	}
 	for i, v := range acc.Txs {
 		mytx := das.txs[v]
@@ -610,7 +972,7 @@ func (das *DevAccountService) FetchTransactions(ctx context.Context, publicKey s
 		}
 		voucher, ok := das.vouchers[mytx.Voucher]
 		if !ok {
-			return nil, fmt.Errorf("voucher %s in tx list but not found in voucher list", mytx.Voucher)
+			return nil, remote.NewError(remote.ErrInternal, "voucher %s in tx list but not found in voucher list", mytx.Voucher)
 		}
 		lasttx = append(lasttx, dataserviceapi.Last10TxResponse{
 			Sender:          mytx.From,
@@ -627,13 +989,15 @@ func (das *DevAccountService) FetchTransactions(ctx context.Context, publicKey s
 }
 
 func (das *DevAccountService) VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error) {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	sym, ok := das.vouchersAddress[address]
 	if !ok {
-		return nil, fmt.Errorf("voucher address %v not found", address)
+		return nil, remote.NewError(remote.ErrNotFound, "voucher address %v not found", address)
 	}
 	voucher, ok := das.vouchers[sym]
 	if !ok {
-		return nil, fmt.Errorf("voucher address %v found but does not resolve", address)
+		return nil, remote.NewError(remote.ErrInternal, "voucher address %v found but does not resolve", address)
 	}
 	return &models.VoucherDataResult{
 		TokenName:      voucher.Name,
@@ -646,6 +1010,9 @@ func (das *DevAccountService) VoucherData(ctx context.Context, address string) (
 }
 
 func (das *DevAccountService) saveTokenTransfer(ctx context.Context, mytx Tx) error {
+	if das.db == nil {
+		return nil
+	}
 	k := das.prefixKeyFor("tx", mytx.Hsh)
 	v, err := json.Marshal(mytx)
 	if err != nil {
@@ -656,47 +1023,68 @@ func (das *DevAccountService) saveTokenTransfer(ctx context.Context, mytx Tx) er
 	return das.db.Put(ctx, []byte(k), v)
 }
 
-// TODO: set default voucher on first received
-// TODO: update balance
-func (das *DevAccountService) TokenTransfer(ctx context.Context, amount, from, to, tokenAddress string) (*models.TokenTransferResponse, error) {
+// transfer moves value units of the voucher at tokenAddress between two
+// accounts, and stores the resulting tx and accounts.
+//
+// A mint credits the recipient without debiting the sender. Transfers from the
+// zero address are always mints.
+//
+// If reason is not empty the tx is stored as failed, and no balances are moved.
+func (das *DevAccountService) transfer(ctx context.Context, value int, from, to, tokenAddress string, mint bool, reason string) (Tx, error) {
 	var b [hashLen]byte
-	value, err := strconv.Atoi(amount)
-	if err != nil {
-		return nil, err
+	var mytx Tx
+	if value < 0 {
+		return mytx, remote.NewError(remote.ErrValidation, "negative amount %d", value)
 	}
+	// the accounts are changed as copies, so that nothing changes if storing them fails
 	accFrom, ok := das.accounts[from]
 	if !ok {
-		return nil, fmt.Errorf("sender account %v not found", from)
+		return mytx, remote.NewError(remote.ErrNotFound, "sender account %v not found", from)
 	}
+	prevFrom := accFrom
+	accFrom = accFrom.clone()
 	accTo, ok := das.accounts[to]
-	if !ok {
+	if ok {
+		accTo = accTo.clone()
+	} else {
 		if !das.toAutoCreate {
-			return nil, fmt.Errorf("recipient account %v not found, and not creating", from)
+			return mytx, remote.NewError(remote.ErrNotFound, "recipient account %v not found, and not creating", to)
+		}
+		accTo = Account{
+			Address: to,
 		}
 	}
 
 	sym, ok := das.vouchersAddress[tokenAddress]
 	if !ok {
-		return nil, fmt.Errorf("voucher address %v not found", tokenAddress)
+		return mytx, remote.NewError(remote.ErrNotFound, "voucher address %v not found", tokenAddress)
 	}
 	voucher, ok := das.vouchers[sym]
 	if !ok {
-		return nil, fmt.Errorf("voucher address %v found but does not resolve", tokenAddress)
+		return mytx, remote.NewError(remote.ErrInternal, "voucher address %v found but does not resolve", tokenAddress)
+	}
+
+	mint = mint || from == zeroAddress
+	if !mint {
+		bal := accFrom.Balances[voucher.Symbol]
+		if bal < value {
+			return mytx, remote.NewError(remote.ErrInsufficientBalance, "insufficient balance of %s for %v: have %d, need %d", voucher.Symbol, from, bal, value)
+		}
 	}
 
 	uid, err := uuid.NewV4()
 	if err != nil {
-		return nil, err
+		return mytx, err
 	}
 	c, err := rand.Read(b[:])
 	if err != nil {
-		return nil, err
+		return mytx, err
 	}
 	if c != hashLen {
-		return nil, fmt.Errorf("tx hash short read: %d", c)
+		return mytx, fmt.Errorf("tx hash short read: %d", c)
 	}
 	hsh := fmt.Sprintf("0x%x", b)
-	mytx := Tx{
+	mytx = Tx{
 		Hsh:     hsh,
 		To:      accTo.Address,
 		From:    accFrom.Address,
@@ -704,38 +1092,116 @@ func (das *DevAccountService) TokenTransfer(ctx context.Context, amount, from, t
 		Value:   value,
 		Track:   uid.String(),
 		When:    time.Now(),
+		Reason:  reason,
+		Mint:    mint,
+	}
+	if reason != "" {
+		err = das.saveTokenTransfer(ctx, mytx)
+		if err != nil {
+			return mytx, err
+		}
+		das.txs[hsh] = mytx
+		das.txsTrack[mytx.Track] = hsh
+		logg.DebugCtxf(ctx, "simulated tx failure", "track", mytx.Track, "reason", reason)
+		return mytx, nil
+	}
+
+	if !mint {
+		accFrom.debit(voucher.Symbol, value)
+		accFrom.Nonce++
+	}
+	accFrom.Txs = append(accFrom.Txs, hsh)
+	if from == to {
+		accTo = accFrom
+	} else {
+		accTo.Txs = append(accTo.Txs, hsh)
 	}
+	accTo.credit(voucher.Symbol, value)
+
 	err = das.saveTokenTransfer(ctx, mytx)
 	if err != nil {
-		return nil, err
+		return mytx, err
 	}
-	das.txs[hsh] = mytx
-	if das.emitterFunc != nil {
-		msg := event.Msg{
-			Typ:  event.EventTokenTransferTag,
-			Item: mytx,
+	err = das.saveAccount(ctx, accFrom)
+	if err != nil {
+		return mytx, err
+	}
+	if from != to {
+		err = das.saveAccount(ctx, accTo)
+		if err != nil {
+			// restore the stored sender, so that no value is lost
+			serr := das.saveAccount(ctx, prevFrom)
+			if serr != nil {
+				logg.ErrorCtxf(ctx, "cannot restore sender account", "address", from, "err", serr)
+			}
+			return mytx, err
 		}
-		err = das.emitterFunc(ctx, msg)
+	}
+	das.accounts[accFrom.Address] = accFrom
+	das.accounts[accTo.Address] = accTo
+	das.txs[hsh] = mytx
+	das.txsTrack[mytx.Track] = hsh
+	return mytx, nil
+}
+
+// revert restores the accounts changed by a tx to their state before it, and
+// marks the tx as failed with the error that caused the revert.
+func (das *DevAccountService) revert(ctx context.Context, mytx Tx, cause error, accs ...Account) {
+	for _, acc := range accs {
+		err := das.saveAccount(ctx, acc)
 		if err != nil {
-			logg.ErrorCtxf(ctx, "emitter returned error", "err", err, "msg", msg)
+			logg.ErrorCtxf(ctx, "cannot restore account", "address", acc.Address, "err", err)
 		}
+		das.accounts[acc.Address] = acc
 	}
+	mytx.Reason = fmt.Sprintf("reverted: %v", cause)
+	err := das.saveTokenTransfer(ctx, mytx)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "cannot store reverted tx", "hash", mytx.Hsh, "err", err)
+	}
+	das.txs[mytx.Hsh] = mytx
+	logg.WarnCtxf(ctx, "reverted tx", "hash", mytx.Hsh, "err", cause)
+}
+
+func (das *DevAccountService) TokenTransfer(ctx context.Context, amount, from, to, tokenAddress string) (*models.TokenTransferResponse, error) {
+	defer das.flushOutbox(ctx)
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	if track, ok := das.replayed(ctx, remote.MethodTokenTransfer); ok {
+		return &models.TokenTransferResponse{
+			TrackingId: track,
+		}, nil
+	}
+	value, err := strconv.Atoi(amount)
+	if err != nil {
+		return nil, remote.NewError(remote.ErrValidation, "invalid amount %s: %v", amount, err)
+	}
+	mytx, err := das.transfer(ctx, value, from, to, tokenAddress, false, das.failure())
+	if err != nil {
+		return nil, err
+	}
+	if mytx.Reason == "" {
+		das.emit(ctx, event.EventTokenTransferTag, das.transferEvent(mytx))
+	}
+	das.remember(ctx, remote.MethodTokenTransfer, mytx.Track)
 	logg.TraceCtxf(ctx, "token transfer created", "tx", mytx)
 	return &models.TokenTransferResponse{
-		TrackingId: uid.String(),
+		TrackingId: mytx.Track,
 	}, nil
 }
 
 func (das *DevAccountService) CheckAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	addr, ok := das.accountsAlias[alias]
 	if !ok {
 		logg.ErrorCtxf(ctx, "alias check failed", "alias", alias)
-		return nil, fmt.Errorf("alias %s not found", alias)
+		return nil, remote.NewError(remote.ErrNotFound, "alias %s not found", alias)
 	}
 	acc, ok := das.accounts[addr]
 	if !ok {
 		logg.ErrorCtxf(ctx, "failed to resolve alias", "alias", alias)
-		return nil, fmt.Errorf("alias %s found but does not resolve", alias)
+		return nil, remote.NewError(remote.ErrInternal, "alias %s found but does not resolve", alias)
 	}
 	return &models.AliasAddress{
 		Address: acc.Address,
@@ -745,7 +1211,7 @@ func (das *DevAccountService) CheckAliasAddress(ctx context.Context, alias strin
 func (das *DevAccountService) applyPhoneAlias(ctx context.Context, publicKey string, phoneNumber string) (bool, error) {
 	if phoneNumber[0] == '+' {
 		if !phone.IsValidPhoneNumber(phoneNumber) {
-			return false, fmt.Errorf("Invalid phoneNumber number: %v", phoneNumber)
+			return false, remote.NewError(remote.ErrInvalidAlias, "Invalid phoneNumber number: %v", phoneNumber)
 		}
 		logg.DebugCtxf(ctx, "matched phoneNumber alias", "phoneNumber", phoneNumber, "address", publicKey)
 		return true, nil
@@ -754,11 +1220,18 @@ func (das *DevAccountService) applyPhoneAlias(ctx context.Context, publicKey str
 }
 
 func (das *DevAccountService) RequestAlias(ctx context.Context, publicKey string, hint string) (*models.RequestAliasResult, error) {
+	defer das.flushOutbox(ctx)
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	return das.requestAlias(ctx, publicKey, hint)
+}
+
+func (das *DevAccountService) requestAlias(ctx context.Context, publicKey string, hint string) (*models.RequestAliasResult, error) {
 	var alias string
 	uid, err := uuid.NewV4()
 	if !aliasRegex.MatchString(hint) {
 		logg.ErrorCtxf(ctx, "alias hint does not match", "key", publicKey, "hint", hint)
-		return nil, fmt.Errorf("alias hint does not match: %s", publicKey)
+		return nil, remote.NewError(remote.ErrInvalidAlias, "alias hint does not match: %s", publicKey)
 	}
 	acc, ok := das.accounts[publicKey]
 	if !ok {
@@ -778,11 +1251,11 @@ func (das *DevAccountService) RequestAlias(ctx context.Context, publicKey string
 	isPhone, err := das.applyPhoneAlias(ctx, publicKey, alias)
 	if err != nil {
 		logg.ErrorCtxf(ctx, "failed to apply phone alias", "public key", publicKey, "alias", alias, "error", err)
-		return nil, fmt.Errorf("phone parser error: %v", err)
+		return nil, remote.NewError(remote.ErrInvalidAlias, "phone parser error: %v", err)
 	}
 	if !isPhone {
 		for true {
-			addr, ok := das.accountsAlias[alias]
+			addr, ok := das.accountsAlias[alias+searchDomain]
 			if !ok {
 				break
 			}
@@ -801,6 +1274,10 @@ func (das *DevAccountService) RequestAlias(ctx context.Context, publicKey string
 		}
 	}
 	logg.DebugCtxf(ctx, "set alias", "addr", publicKey, "alias", alias)
+	das.emit(ctx, event.EventAliasTag, event.EventAlias{
+		Account: publicKey,
+		Alias:   alias,
+	})
 	return &models.RequestAliasResult{
 		Alias: alias,
 	}, nil
@@ -830,6 +1307,9 @@ func (das *DevAccountService) SendAddressSMS(ctx context.Context, publicKey, ori
 
 func (das *DevAccountService) FetchTopPools(ctx context.Context) ([]dataserviceapi.PoolDetails, error) {
 	var topPools []dataserviceapi.PoolDetails
+
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	for _, p := range das.pools {
 		topPools = append(topPools, dataserviceapi.PoolDetails{
 			PoolName:            p.Name,
@@ -855,16 +1335,26 @@ func (das *DevAccountService) RetrievePoolDetails(ctx context.Context, sym strin
 func (das *DevAccountService) GetPoolSwappableFromVouchers(ctx context.Context, poolAddress, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
 	var swapFromList []dataserviceapi.TokenHoldings
 
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	p, ok := das.pools[poolAddress]
 	if !ok {
-		return nil, fmt.Errorf("Invalid pool address: %v", poolAddress)
+		return nil, remote.NewError(remote.ErrNotFound, "Invalid pool address: %v", poolAddress)
+	}
+	acc, ok := das.accounts[publicKey]
+	if !ok {
+		return nil, remote.NewError(remote.ErrNotFound, "account not found (publickey): %v", publicKey)
 	}
 	for _, v := range p.Vouchers {
+		bal := acc.Balances[v.Symbol]
+		if bal <= 0 {
+			continue
+		}
 		swapFromList = append(swapFromList, dataserviceapi.TokenHoldings{
 			TokenAddress:  v.Address,
 			TokenSymbol:   v.Symbol,
-			TokenDecimals: string(defaultDecimals),
-			Balance:       fmt.Sprintf("%f", defaultVoucherBalance),
+			TokenDecimals: strconv.Itoa(v.Decimals),
+			Balance:       strconv.Itoa(bal),
 		})
 	}
 
@@ -873,9 +1363,12 @@ func (das *DevAccountService) GetPoolSwappableFromVouchers(ctx context.Context,
 
 func (das *DevAccountService) GetPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
 	var swapToList []dataserviceapi.TokenHoldings
+
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	_, ok := das.pools[poolAddress]
 	if !ok {
-		return nil, fmt.Errorf("Invalid pool address: %v", poolAddress)
+		return nil, remote.NewError(remote.ErrNotFound, "Invalid pool address: %v", poolAddress)
 	}
 	for _, voucher := range das.vouchers {
 		swapToList = append(swapToList, dataserviceapi.TokenHoldings{
@@ -888,17 +1381,27 @@ func (das *DevAccountService) GetPoolSwappableVouchers(ctx context.Context, pool
 }
 
 func (das *DevAccountService) GetSwapFromTokenMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.MaxLimitResult, error) {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
 	p, ok := das.pools[poolAddress]
 	if !ok {
-		return nil, fmt.Errorf("Pool address: %v not found ", poolAddress)
+		return nil, remote.NewError(remote.ErrNotFound, "Pool address: %v not found ", poolAddress)
 	}
-	limit, ok := p.PoolLimit[fromTokenAddress]
-	if !ok {
-		return nil, fmt.Errorf("Token address: %v not found in the pool", fromTokenAddress)
+	limit, err := p.limit(fromTokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	// the user cannot swap more than they hold
+	acc, ok := das.accounts[publicKey]
+	if ok {
+		sym := das.vouchersAddress[fromTokenAddress]
+		if acc.Balances[sym] < limit {
+			limit = acc.Balances[sym]
+		}
 	}
 
 	return &models.MaxLimitResult{
-		Max: limit,
+		Max: strconv.Itoa(limit),
 	}, nil
 }
 
@@ -1,9 +1,19 @@
 package dev
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"git.defalsify.org/vise.git/db"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
 	"git.grassecon.net/grassrootseconomics/visedriver/testutil/mocks"
 )
 
@@ -75,3 +85,805 @@ func TestApiRequestAlias(t *testing.T) {
 		t.Fatalf("expected '%s', got '%s'", addr, rc.Address)
 	}
 }
+
+func TestApiIdempotentTransfer(t *testing.T) {
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	svc := NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 42)
+	ra, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	voucher := svc.vouchers["FOO"]
+
+	ctx = remote.WithIdempotencyKey(ctx, "deadbeef")
+	r, err := svc.TokenTransfer(ctx, "1", ra.PublicKey, rb.PublicKey, voucher.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txCount := len(svc.txs)
+	rr, err := svc.TokenTransfer(ctx, "1", ra.PublicKey, rb.PublicKey, voucher.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.TrackingId != r.TrackingId {
+		t.Fatalf("expected replayed tracking id '%s', got '%s'", r.TrackingId, rr.TrackingId)
+	}
+	if len(svc.txs) != txCount {
+		t.Fatalf("expected no new tx on replay")
+	}
+
+	ctx = remote.WithIdempotencyKey(ctx, "beeffeed")
+	rr, err = svc.TokenTransfer(ctx, "1", ra.PublicKey, rb.PublicKey, voucher.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.TrackingId == r.TrackingId {
+		t.Fatalf("expected new tracking id for new key")
+	}
+}
+
+func TestApiTransferBalances(t *testing.T) {
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	svc := NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 42)
+	svc.WithAutoVoucher(ctx, "BAR", 13)
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		r, err := svc.CreateAccount(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, r.PublicKey)
+	}
+	foo := svc.vouchers["FOO"]
+
+	_, err := svc.TokenTransfer(ctx, "40", addrs[1], addrs[2], foo.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.TokenTransfer(ctx, "3", addrs[1], addrs[2], foo.Address)
+	if !errors.Is(err, remote.ErrInsufficientBalance) {
+		t.Fatalf("expected insufficient balance, got %v", err)
+	}
+
+	r, err := svc.CheckBalance(ctx, addrs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != "2" || r.Nonce.String() != "1" {
+		t.Fatalf("expected balance 2 nonce 1, got %s %s", r.Balance, r.Nonce)
+	}
+	r, err = svc.CheckBalance(ctx, addrs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != "82" {
+		t.Fatalf("expected balance 82, got %s", r.Balance)
+	}
+	// the default account funds others without being debited
+	r, err = svc.CheckBalance(ctx, addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != "42" {
+		t.Fatalf("expected balance 42, got %s", r.Balance)
+	}
+
+	holdings, err := svc.FetchVouchers(ctx, addrs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holdings) != 2 {
+		t.Fatalf("expected 2 holdings, got %d", len(holdings))
+	}
+	if holdings[0].TokenSymbol != "BAR" || holdings[0].Balance != "13" || holdings[1].TokenSymbol != "FOO" || holdings[1].Balance != "82" {
+		t.Fatalf("unexpected holdings %v", holdings)
+	}
+}
+
+func TestApiPoolSwap(t *testing.T) {
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	svc := NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 600)
+	svc.WithAutoVoucher(ctx, "BAR", 0)
+	err := svc.RegisterPool(ctx, "testpool", "TPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pool Pool
+	for _, p := range svc.pools {
+		pool = p
+	}
+	foo := svc.vouchers["FOO"]
+	bar := svc.vouchers["BAR"]
+
+	q, err := svc.GetPoolSwapQuote(ctx, "100", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10000 * 100 / (10000 + 100)
+	if q.OutValue != "99" {
+		t.Fatalf("expected quote 99, got %s", q.OutValue)
+	}
+
+	_, err = svc.PoolSwap(ctx, "501", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if !errors.Is(err, remote.ErrValidation) {
+		t.Fatalf("expected pool limit error, got %v", err)
+	}
+	_, err = svc.PoolSwap(ctx, "100", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := svc.accounts[r.PublicKey]
+	if acc.Balances["FOO"] != 500 || acc.Balances["BAR"] != 99 {
+		t.Fatalf("unexpected balances %v", acc.Balances)
+	}
+	reserves := svc.accounts[pool.Address].Balances
+	if reserves["FOO"] != 10100 || reserves["BAR"] != 9901 {
+		t.Fatalf("unexpected reserves %v", reserves)
+	}
+
+	err = svc.SetPoolRate(ctx, pool.Address, bar.Address, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err = svc.GetPoolSwapQuote(ctx, "100", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9901 * 100 / (10100 + 100) / 2
+	if q.OutValue != "48" {
+		t.Fatalf("expected quote 48, got %s", q.OutValue)
+	}
+	err = svc.SetPoolRate(ctx, pool.Address, foo.Address, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err = svc.GetPoolSwapQuote(ctx, "100", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9901 * 100 / (10100 + 100) * 4 / 2
+	if q.OutValue != "194" {
+		t.Fatalf("expected quote 194, got %s", q.OutValue)
+	}
+	err = svc.SetPoolRate(ctx, pool.Address, foo.Address, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.GetPoolSwapQuote(ctx, "100", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if !errors.Is(err, remote.ErrValidation) {
+		t.Fatalf("expected error on quote above reserve, got %v", err)
+	}
+
+	// an empty input reserve would give away the whole output reserve
+	svc.accounts[pool.Address].Balances["FOO"] = 0
+	_, err = svc.GetPoolSwapQuote(ctx, "1", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if !errors.Is(err, remote.ErrValidation) {
+		t.Fatalf("expected error on empty reserve, got %v", err)
+	}
+}
+
+func TestApiRegisterPoolReload(t *testing.T) {
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	svc := NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 42)
+	err := svc.RegisterPool(ctx, "testpool", "TPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as when restarting with the same db
+	svc = NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 42)
+	err = svc.RegisterPool(ctx, "testpool", "TPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserves := svc.accounts[poolAddressFor("TPL")].Balances
+	if reserves["FOO"] != defaultPoolReserve {
+		t.Fatalf("expected reserve not minted again, got %v", reserves)
+	}
+}
+
+// failingDb fails the put with the given number, counting from 1.
+type failingDb struct {
+	db.Db
+	puts   int
+	failAt int
+}
+
+func (fdb *failingDb) Put(ctx context.Context, key []byte, val []byte) error {
+	fdb.puts++
+	if fdb.puts == fdb.failAt {
+		return errors.New("disk full")
+	}
+	return fdb.Db.Put(ctx, key, val)
+}
+
+func TestApiPoolSwapRevert(t *testing.T) {
+	ctx := context.Background()
+	svc := NewDevAccountService(ctx, mocks.NewMemStorageService(ctx)).WithAutoVoucher(ctx, "FOO", 600)
+	svc.WithAutoVoucher(ctx, "BAR", 0)
+	err := svc.RegisterPool(ctx, "testpool", "TPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pool Pool
+	for _, p := range svc.pools {
+		pool = p
+	}
+	foo := svc.vouchers["FOO"]
+	bar := svc.vouchers["BAR"]
+
+	// the first leg stores its tx and two accounts, so the second fails
+	// storing the pool account
+	svc.db = &failingDb{
+		Db:     svc.db,
+		failAt: 5,
+	}
+	_, err = svc.PoolSwap(ctx, "100", r.PublicKey, foo.Address, pool.Address, bar.Address)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	acc := svc.accounts[r.PublicKey]
+	if acc.Balances["FOO"] != 600 || acc.Balances["BAR"] != 0 {
+		t.Fatalf("expected balances restored, got %v", acc.Balances)
+	}
+	reserves := svc.accounts[pool.Address].Balances
+	if reserves["FOO"] != 10000 || reserves["BAR"] != 10000 {
+		t.Fatalf("expected reserves restored, got %v", reserves)
+	}
+	var reverted int
+	for _, mytx := range svc.txs {
+		if strings.HasPrefix(mytx.Reason, "reverted") {
+			reverted++
+		}
+	}
+	if reverted != 1 {
+		t.Fatalf("expected first leg marked as reverted, got %d", reverted)
+	}
+
+	// and the restored state is what is stored
+	other := NewDevAccountService(ctx, nil)
+	other.db = svc.db
+	err = other.loadAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.accounts[r.PublicKey].Balances["FOO"] != 600 || other.accounts[pool.Address].Balances["FOO"] != 10000 {
+		t.Fatalf("expected restored accounts stored, got %v %v", other.accounts[r.PublicKey].Balances, other.accounts[pool.Address].Balances)
+	}
+}
+
+func TestApiTrackTransaction(t *testing.T) {
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	svc := NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 42)
+	svc.WithConfirmDelay(time.Hour)
+	alice, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo := svc.vouchers["FOO"]
+
+	r, err := svc.TokenTransfer(ctx, "2", alice.PublicKey, bob.PublicKey, foo.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := svc.TrackTransaction(ctx, r.TrackingId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != models.TxStatusPending || s.TxHash != "" {
+		t.Fatalf("expected pending without hash, got %v", s)
+	}
+
+	svc.WithConfirmDelay(0).WithFailureRate(1)
+	s, err = svc.TrackTransaction(ctx, r.TrackingId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != models.TxStatusConfirmed || s.TxHash == "" {
+		t.Fatalf("expected confirmed with hash, got %v", s)
+	}
+
+	r, err = svc.TokenTransfer(ctx, "2", alice.PublicKey, bob.PublicKey, foo.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = svc.TrackTransaction(ctx, r.TrackingId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != models.TxStatusFailed || s.FailureReason == "" {
+		t.Fatalf("expected failed with reason, got %v", s)
+	}
+	if svc.accounts[alice.PublicKey].Balances["FOO"] != 40 {
+		t.Fatalf("expected failed transfer to leave balance, got %d", svc.accounts[alice.PublicKey].Balances["FOO"])
+	}
+
+	_, err = svc.TrackTransaction(ctx, "nonexistent")
+	if !errors.Is(err, remote.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestApiOutbox(t *testing.T) {
+	var msgs []event.Msg
+	var down bool
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	emitter := func(ctx context.Context, msg event.Msg) error {
+		if down {
+			return errors.New("consumer down")
+		}
+		msgs = append(msgs, msg)
+		return nil
+	}
+	svc := NewDevAccountService(ctx, storageService).WithEmitter(emitter).WithOutboxBackoff(time.Hour, time.Hour)
+
+	down = true
+	_, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.OutboxPending() != 2 {
+		t.Fatalf("expected 2 pending, got %d", svc.OutboxPending())
+	}
+
+	// the retry is not yet due
+	down = false
+	err = svc.RetryOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("expected no delivery before retry is due, got %d", len(msgs))
+	}
+	err = svc.DrainOutbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || svc.OutboxPending() != 0 {
+		t.Fatalf("expected 2 delivered, got %d with %d pending", len(msgs), svc.OutboxPending())
+	}
+	first := msgs[0]
+
+	// entries survive a restart
+	msgs = nil
+	svc = NewDevAccountService(ctx, storageService).WithEmitter(emitter)
+	err = svc.ReplayOutbox(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 replayed, got %d", len(msgs))
+	}
+	ev, ok := msgs[0].Item.(event.EventCustodialRegistration)
+	if !ok || msgs[0].Id != first.Id || ev != first.Item {
+		t.Fatalf("expected replay of %v, got %v", first, msgs[0])
+	}
+}
+
+func TestApiOutboxBounds(t *testing.T) {
+	var msgs []event.Msg
+	var pending []int
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+
+	// events are recorded without an emitter, growing the outbox while it is
+	// full of them
+	svc := NewDevAccountService(ctx, storageService).WithOutboxSize(2)
+	for i := 0; i < 3; i++ {
+		_, err := svc.CreateAccount(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if svc.OutboxPending() != 3 || svc.outboxSize != 4 {
+		t.Fatalf("expected 3 pending in 4 slots, got %d in %d", svc.OutboxPending(), svc.outboxSize)
+	}
+
+	// undelivered events survive a restart with a smaller size
+	svc = NewDevAccountService(ctx, storageService).WithOutboxSize(1)
+	if svc.OutboxPending() != 3 || svc.outboxSize != 3 {
+		t.Fatalf("expected 3 pending in 3 slots, got %d in %d", svc.OutboxPending(), svc.outboxSize)
+	}
+
+	// they are delivered once an emitter is set, which may call the service
+	emitter := func(ctx context.Context, msg event.Msg) error {
+		pending = append(pending, svc.OutboxPending())
+		msgs = append(msgs, msg)
+		return nil
+	}
+	svc.WithEmitter(emitter)
+	if len(msgs) != 3 || svc.OutboxPending() != 0 {
+		t.Fatalf("expected 3 delivered, got %d with %d pending", len(msgs), svc.OutboxPending())
+	}
+	if fmt.Sprint(pending) != "[3 2 1]" {
+		t.Fatalf("expected each delivery recorded after the emitter returned, got %v", pending)
+	}
+
+	// delivered entries are overwritten by newer ones
+	svc.WithOutboxSize(2)
+	for i := 0; i < 3; i++ {
+		_, err := svc.CreateAccount(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(svc.outbox) != 2 || len(msgs) != 6 {
+		t.Fatalf("expected 2 entries kept and 6 delivered, got %d and %d", len(svc.outbox), len(msgs))
+	}
+
+	// a corrupt entry does not keep the rest from loading
+	k := svc.prefixKeyFor("outbox", "garbage")
+	svc.db.SetPrefix(db.DATATYPE_USERDATA)
+	err := svc.db.Put(ctx, k, []byte("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs = nil
+	n := len(svc.accounts)
+	svc = NewDevAccountService(ctx, storageService).WithEmitter(emitter)
+	err = svc.ReplayOutbox(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || len(svc.accounts) != n {
+		t.Fatalf("expected 2 replayed and %d accounts, got %d and %d", n, len(msgs), len(svc.accounts))
+	}
+}
+
+func TestApiReplay(t *testing.T) {
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	svc := NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 42)
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		r, err := svc.CreateAccount(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, r.PublicKey)
+	}
+	foo := svc.vouchers["FOO"]
+	r, err := svc.TokenTransfer(ctx, "2", addrs[0], addrs[1], foo.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []event.Msg
+	emitter := func(ctx context.Context, msg event.Msg) error {
+		msgs = append(msgs, msg)
+		return nil
+	}
+	svc = NewDevAccountService(ctx, storageService).WithAutoVoucher(ctx, "FOO", 42)
+	err = svc.Replay(ctx, time.Time{}, emitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		event.EventRegistrationTag,
+		event.EventTokenMintTag,
+		event.EventRegistrationTag,
+		event.EventTokenMintTag,
+		event.EventTokenTransferTag,
+	}
+	if len(msgs) != len(expect) {
+		t.Fatalf("expected %d events, got %v", len(expect), msgs)
+	}
+	for i, typ := range expect {
+		if msgs[i].Typ != typ {
+			t.Fatalf("expected %s at %d, got %s", typ, i, msgs[i].Typ)
+		}
+	}
+	ev := msgs[4].Item.(event.EventTokenTransfer)
+	if ev.From != addrs[0] || ev.VoucherAddress != foo.Address || ev.Value != 2 {
+		t.Fatalf("unexpected transfer event %v", ev)
+	}
+
+	hsh := svc.txsTrack[r.TrackingId]
+	msgs = nil
+	err = svc.Replay(ctx, svc.txs[hsh].When, emitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Typ != event.EventTokenTransferTag {
+		t.Fatalf("expected only the transfer, got %v", msgs)
+	}
+}
+
+func TestApiSnapshot(t *testing.T) {
+	ctx := context.Background()
+	svc := NewDevAccountService(ctx, mocks.NewMemStorageService(ctx)).WithAutoVoucher(ctx, "FOO", 42)
+	err := svc.RegisterPool(ctx, "testpool", "TPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo := svc.vouchers["FOO"]
+	_, err = svc.TokenTransfer(ctx, "2", alice.PublicKey, bob.PublicKey, foo.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.RequestAlias(ctx, bob.PublicKey, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = svc.Export(ctx, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := b.String()
+	for _, k := range []string{`"address":`, `"balances":`, `"hash":`, `"poolLimit":`} {
+		if !strings.Contains(snap, k) {
+			t.Fatalf("expected key %s in snapshot %s", k, snap)
+		}
+	}
+
+	other := NewDevAccountService(ctx, mocks.NewMemStorageService(ctx))
+	err = other.Import(ctx, strings.NewReader(snap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	err = other.Export(ctx, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != snap {
+		t.Fatalf("expected identical export after import, got\n%s\nwant\n%s", b.String(), snap)
+	}
+	r, err := other.CheckBalance(ctx, bob.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != "44" {
+		t.Fatalf("expected balance 44, got %s", r.Balance)
+	}
+	rc, err := other.CheckAliasAddress(ctx, "bob.sarafu.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Address != bob.PublicKey {
+		t.Fatalf("expected alias of %s, got %s", bob.PublicKey, rc.Address)
+	}
+
+	err = other.Import(ctx, strings.NewReader(`{"version": 2}`))
+	if err == nil {
+		t.Fatalf("expected unsupported version error")
+	}
+
+	// a failed import leaves the service unchanged
+	third := NewDevAccountService(ctx, mocks.NewMemStorageService(ctx)).WithAutoVoucher(ctx, "BAR", 1)
+	third.db = &failingDb{
+		Db:     third.db,
+		failAt: 3,
+	}
+	err = third.Import(ctx, strings.NewReader(snap))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(third.vouchers) != 1 || len(third.accounts) != 1 {
+		t.Fatalf("expected unchanged state, got %v and %v", third.vouchers, third.accounts)
+	}
+}
+
+func TestApiSeed(t *testing.T) {
+	seed := `
+vouchers:
+  - symbol: FOO
+    name: Foo Coin
+    decimals: 2
+    commodity: maize
+    location: Kilifi
+    autoValue: 10
+  - symbol: BAR
+accounts:
+  - address: "0x0000000000000000000000000000000000000001"
+    alias: alice
+    balances:
+      FOO: 100
+      BAR: 5
+  - alias: bob
+pools:
+  - name: testpool
+    symbol: TPL
+    vouchers: [FOO, BAR]
+    limits:
+      FOO: 50
+    fee: 100
+`
+	ctx := context.Background()
+	storageService := mocks.NewMemStorageService(ctx)
+	svc, err := NewDevAccountServiceFromSeed(ctx, storageService, strings.NewReader(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := "0x0000000000000000000000000000000000000001"
+	foo := svc.vouchers["FOO"]
+	if foo.Name != "Foo Coin" || foo.Decimals != 2 || foo.Location != "Kilifi" {
+		t.Fatalf("unexpected voucher %v", foo)
+	}
+	if svc.vouchers["BAR"].Decimals != defaultDecimals {
+		t.Fatalf("expected default decimals, got %v", svc.vouchers["BAR"])
+	}
+	// the auto voucher value is added to the seeded balance
+	acc := svc.accounts[alice]
+	if acc.Balances["FOO"] != 110 || acc.Balances["BAR"] != 5 {
+		t.Fatalf("unexpected balances %v", acc.Balances)
+	}
+	r, err := svc.CheckAliasAddress(ctx, "bob.sarafu.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.accounts[r.Address].Balances["FOO"] != 10 {
+		t.Fatalf("expected bob funded by auto voucher, got %v", svc.accounts[r.Address].Balances)
+	}
+	var pool Pool
+	for _, p := range svc.pools {
+		pool = p
+	}
+	limit, err := pool.limit(foo.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Vouchers) != 2 || pool.Fee != 100 || limit != 50 {
+		t.Fatalf("unexpected pool %v", pool)
+	}
+
+	// vouchers and auto voucher values are restored from storage
+	n := len(svc.accounts)
+	svc = NewDevAccountService(ctx, storageService)
+	if svc.vouchers["FOO"] != foo || svc.autoVoucherValue["FOO"] != 10 {
+		t.Fatalf("expected stored voucher %v with auto value, got %v", foo, svc.vouchers["FOO"])
+	}
+
+	// the seed is not applied again on restart
+	svc, err = NewDevAccountServiceFromSeed(ctx, storageService, strings.NewReader(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.accounts) != n || svc.accounts[alice].Balances["FOO"] != 110 {
+		t.Fatalf("expected unchanged state, got %d accounts with alice %v", len(svc.accounts), svc.accounts[alice])
+	}
+
+	// nothing is created from an invalid seed
+	storageService = mocks.NewMemStorageService(ctx)
+	_, err = NewDevAccountServiceFromSeed(ctx, storageService, strings.NewReader(`{"vouchers": [{"symbol": "BAZ"}], "accounts": [{"balances": {"FOO": 1}}]}`))
+	if err == nil {
+		t.Fatalf("expected unknown voucher error")
+	}
+	svc = NewDevAccountService(ctx, storageService)
+	if len(svc.vouchers) != 0 {
+		t.Fatalf("expected no vouchers, got %v", svc.vouchers)
+	}
+}
+
+func TestApiConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	var events int
+	ctx := context.Background()
+	emitter := func(ctx context.Context, msg event.Msg) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events++
+		return nil
+	}
+	svc := NewDevAccountService(ctx, mocks.NewMemStorageService(ctx)).WithAutoVoucher(ctx, "FOO", 1000)
+	svc.WithAutoVoucher(ctx, "BAR", 1000).WithEmitter(emitter)
+	err := svc.RegisterPool(ctx, "testpool", "TPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addrs []string
+	for i := 0; i < 8; i++ {
+		r, err := svc.CreateAccount(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, r.PublicKey)
+	}
+	var pool Pool
+	for _, p := range svc.pools {
+		pool = p
+	}
+	foo := svc.vouchers["FOO"]
+	bar := svc.vouchers["BAR"]
+	supply := func(sym string) int {
+		svc.mu.RLock()
+		defer svc.mu.RUnlock()
+		var c int
+		for _, acc := range svc.accounts {
+			c += acc.Balances[sym]
+		}
+		return c
+	}
+	fooSupply := supply("FOO")
+	barSupply := supply("BAR")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(addrs))
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				to := addrs[(i+j+1)%len(addrs)]
+				_, err := svc.TokenTransfer(ctx, "3", addr, to, foo.Address)
+				if err != nil && !errors.Is(err, remote.ErrInsufficientBalance) {
+					errs <- err
+					return
+				}
+				_, err = svc.PoolSwap(ctx, "5", addr, foo.Address, pool.Address, bar.Address)
+				if err != nil && !errors.Is(err, remote.ErrInsufficientBalance) {
+					errs <- err
+					return
+				}
+				_, err = svc.RequestAlias(ctx, addr, "user")
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = svc.CheckBalance(ctx, to)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = svc.FetchTransactions(ctx, addr)
+				if err != nil {
+					errs <- err
+					return
+				}
+				svc.GetAliases(ctx)
+			}
+		}(i, addr)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if supply("FOO") != fooSupply || supply("BAR") != barSupply {
+		t.Fatalf("expected supply %d FOO %d BAR, got %d %d", fooSupply, barSupply, supply("FOO"), supply("BAR"))
+	}
+	aliases := svc.GetAliases(ctx)
+	if len(aliases) != len(addrs) {
+		t.Fatalf("expected %d distinct aliases, got %v", len(addrs), aliases)
+	}
+	if svc.OutboxPending() != 0 || events == 0 {
+		t.Fatalf("expected all events delivered, %d pending", svc.OutboxPending())
+	}
+}
@@ -0,0 +1,440 @@
+package dev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
+)
+
+const (
+	defaultOutboxBaseDelay = time.Second
+	defaultOutboxMaxDelay  = 5 * time.Minute
+	// number of slots of the outbox ring
+	defaultOutboxSize = 1024
+	// name under which the number of slots is stored with the outbox
+	outboxSizeSlot = "size"
+)
+
+// outboxEntry is an event recorded before it is emitted.
+type outboxEntry struct {
+	Seq uint64 `json:"seq"`
+	Typ string `json:"typ"`
+	// Event is the event as encoded by event.JSON.
+	Event       json.RawMessage `json:"event"`
+	Created     time.Time       `json:"created"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError"`
+	Delivered   bool            `json:"delivered"`
+	// the emitted message, restored from Event when loaded from the db.
+	msg event.Msg
+	// the slot the entry was loaded from.
+	slot uint64
+}
+
+// WithOutboxBackoff sets the delay before the first retry of a failed event
+// delivery, and the maximum delay between retries.
+func (das *DevAccountService) WithOutboxBackoff(base time.Duration, max time.Duration) *DevAccountService {
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	das.outboxBaseDelay = base
+	das.outboxMaxDelay = max
+	return das
+}
+
+// WithOutboxSize sets the number of events kept in the outbox, delivered or
+// not.
+//
+// The outbox grows beyond that while it is full of undelivered events, and is
+// never made smaller than needed to keep them.
+func (das *DevAccountService) WithOutboxSize(size int) *DevAccountService {
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	ctx := context.Background()
+	err := das.resizeOutbox(ctx, size)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "cannot resize outbox", "size", size, "err", err)
+	}
+	return das
+}
+
+// loadOutboxEntry reads an outbox slot from the db. The entries are only
+// restored by restoreOutbox, once the size of the outbox is known.
+//
+// Corrupt entries are skipped, as they cannot be delivered anyway, and must
+// not keep the rest of the state from being loaded.
+func (das *DevAccountService) loadOutboxEntry(ctx context.Context, slot string, v []byte) error {
+	var e outboxEntry
+
+	if slot == outboxSizeSlot {
+		size, err := strconv.Atoi(string(v))
+		if err != nil || size < 1 {
+			logg.ErrorCtxf(ctx, "skipping malformed outbox size", "size", string(v))
+			return nil
+		}
+		das.outboxSize = size
+		return nil
+	}
+	n, err := strconv.ParseUint(slot, 10, 64)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "skipping malformed outbox slot", "slot", slot)
+		return nil
+	}
+	err = json.Unmarshal(v, &e)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "skipping malformed outbox entry", "slot", slot, "err", err)
+		return nil
+	}
+	if e.Seq == 0 {
+		// the slot is empty
+		return nil
+	}
+	e.msg, err = event.JSON.Decode(e.Event)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "skipping malformed outbox event", "slot", slot, "seq", e.Seq, "err", err)
+		return nil
+	}
+	e.slot = n
+	das.outboxLoaded = append(das.outboxLoaded, e)
+	return nil
+}
+
+// restoreOutbox restores the entries read by loadOutboxEntry. Entries left in
+// slots from before the outbox was resized are skipped.
+func (das *DevAccountService) restoreOutbox(ctx context.Context) {
+	for _, e := range das.outboxLoaded {
+		if e.Seq%uint64(das.outboxSize) != e.slot {
+			logg.TraceCtxf(ctx, "skipping stale outbox entry", "slot", e.slot, "seq", e.Seq)
+			continue
+		}
+		das.outbox[e.Seq] = e
+		if e.Seq > das.outboxSeq {
+			das.outboxSeq = e.Seq
+		}
+	}
+	das.outboxLoaded = nil
+	das.pruneOutbox()
+}
+
+func (das *DevAccountService) saveOutboxSlot(ctx context.Context, slot string, v []byte) error {
+	if das.db == nil {
+		return nil
+	}
+	k := das.prefixKeyFor("outbox", slot)
+	das.db.SetSession("")
+	das.db.SetPrefix(db.DATATYPE_USERDATA)
+	return das.db.Put(ctx, []byte(k), v)
+}
+
+// saveOutboxEntry stores an entry in its slot of the outbox ring, so that
+// storage does not grow with every event.
+func (das *DevAccountService) saveOutboxEntry(ctx context.Context, e outboxEntry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return das.saveOutboxSlot(ctx, fmt.Sprintf("%020d", e.Seq%uint64(das.outboxSize)), v)
+}
+
+// resizeOutbox changes the number of slots of the outbox to size, or to the
+// number needed to keep the undelivered events if that is larger.
+//
+// Every slot of the new ring is written, so that none is left holding an entry
+// of the old one.
+func (das *DevAccountService) resizeOutbox(ctx context.Context, size int) error {
+	if size < 1 {
+		return fmt.Errorf("invalid outbox size %d", size)
+	}
+	for _, e := range das.outbox {
+		if !e.Delivered && das.outboxSeq-e.Seq >= uint64(size) {
+			size = int(das.outboxSeq-e.Seq) + 1
+		}
+	}
+	if size == das.outboxSize {
+		return nil
+	}
+	das.outboxSize = size
+	das.pruneOutbox()
+	for slot := 0; slot < size; slot++ {
+		seq := das.outboxSeq - das.outboxSeq%uint64(size) + uint64(slot)
+		if seq > das.outboxSeq {
+			seq -= uint64(size)
+		}
+		e, ok := das.outbox[seq]
+		if ok {
+			err := das.saveOutboxEntry(ctx, e)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := das.saveOutboxSlot(ctx, fmt.Sprintf("%020d", slot), []byte("{}"))
+		if err != nil {
+			return err
+		}
+	}
+	err := das.saveOutboxSlot(ctx, outboxSizeSlot, []byte(strconv.Itoa(size)))
+	if err != nil {
+		return err
+	}
+	logg.DebugCtxf(ctx, "resized outbox", "size", size)
+	return nil
+}
+
+// enqueue records an event in the outbox.
+//
+// The event is given its id and timestamp here, so that they are the same on
+// every delivery. If the slot of the event still holds an undelivered one, the
+// outbox is doubled in size first.
+func (das *DevAccountService) enqueue(ctx context.Context, typ string, item any) error {
+	seq := das.outboxSeq + 1
+	if seq > uint64(das.outboxSize) {
+		old, ok := das.outbox[seq-uint64(das.outboxSize)]
+		if ok && !old.Delivered {
+			logg.WarnCtxf(ctx, "outbox full, growing", "size", das.outboxSize, "pending", das.outboxPending())
+			err := das.resizeOutbox(ctx, das.outboxSize*2)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	v, err := event.JSON.Encode(event.Msg{
+		Typ:  typ,
+		Item: item,
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := event.JSON.Decode(v)
+	if err != nil {
+		return err
+	}
+	e := outboxEntry{
+		Seq:     seq,
+		Typ:     typ,
+		Event:   v,
+		Created: msg.Time,
+		msg:     msg,
+	}
+	err = das.saveOutboxEntry(ctx, e)
+	if err != nil {
+		return err
+	}
+	das.outboxSeq = e.Seq
+	das.outbox[e.Seq] = e
+	das.pruneOutbox()
+	return nil
+}
+
+// pruneOutbox drops the delivered entries whose slots are to be reused by
+// newer ones.
+func (das *DevAccountService) pruneOutbox() {
+	if das.outboxSeq <= uint64(das.outboxSize) {
+		return
+	}
+	oldest := das.outboxSeq - uint64(das.outboxSize)
+	for seq, e := range das.outbox {
+		if seq <= oldest && e.Delivered {
+			delete(das.outbox, seq)
+		}
+	}
+}
+
+// backoff returns the delay before the next delivery attempt, after the given
+// number of failed attempts.
+func (das *DevAccountService) backoff(attempts int) time.Duration {
+	d := das.outboxBaseDelay
+	for i := 1; i < attempts && d < das.outboxMaxDelay; i++ {
+		d *= 2
+	}
+	if d > das.outboxMaxDelay {
+		d = das.outboxMaxDelay
+	}
+	return d
+}
+
+// recordDelivery records the outcome of the delivery of an outbox entry.
+func (das *DevAccountService) recordDelivery(ctx context.Context, seq uint64, err error) {
+	e, ok := das.outbox[seq]
+	if !ok {
+		return
+	}
+	e.Attempts++
+	if err != nil {
+		e.LastError = err.Error()
+		e.NextAttempt = time.Now().Add(das.backoff(e.Attempts))
+	} else {
+		e.Delivered = true
+		e.LastError = ""
+	}
+	das.outbox[e.Seq] = e
+	serr := das.saveOutboxEntry(ctx, e)
+	if serr != nil {
+		logg.ErrorCtxf(ctx, "cannot update outbox entry", "seq", e.Seq, "err", serr)
+	}
+}
+
+// outboxSeqs returns the sequence numbers of the outbox entries, in order.
+func (das *DevAccountService) outboxSeqs() []uint64 {
+	var seqs []uint64
+	for seq := range das.outbox {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	return seqs
+}
+
+// nextDue returns the first undelivered outbox entry, if it is due.
+//
+// Unless force is set, an entry whose retry is not yet due is not returned,
+// so that events are never delivered out of order.
+func (das *DevAccountService) nextDue(force bool) (outboxEntry, bool) {
+	now := time.Now()
+	for _, seq := range das.outboxSeqs() {
+		e := das.outbox[seq]
+		if e.Delivered {
+			continue
+		}
+		if !force && now.Before(e.NextAttempt) {
+			return e, false
+		}
+		return e, true
+	}
+	return outboxEntry{}, false
+}
+
+// deliverOutbox delivers the due events in order, stopping at the first failure.
+//
+// The emitter is called without the service being locked, so that it may be
+// slow or call back into the service. The caller must hold outboxMu, so that
+// deliveries are not interleaved.
+func (das *DevAccountService) deliverOutbox(ctx context.Context, force bool) error {
+	for {
+		das.mu.RLock()
+		fn := das.emitterFunc
+		e, ok := das.nextDue(force)
+		das.mu.RUnlock()
+		if fn == nil || !ok {
+			return nil
+		}
+		err := fn(ctx, e.msg)
+		das.mu.Lock()
+		das.recordDelivery(ctx, e.Seq, err)
+		das.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("delivery of event %d failed: %v", e.Seq, err)
+		}
+	}
+}
+
+// flushOutbox delivers the due events, unless a delivery is already running,
+// which then also delivers those. It must be called without the service being
+// locked.
+func (das *DevAccountService) flushOutbox(ctx context.Context) {
+	for das.outboxMu.TryLock() {
+		err := das.deliverOutbox(ctx, false)
+		das.outboxMu.Unlock()
+		if err != nil {
+			logg.WarnCtxf(ctx, "event delivery failed, will retry", "err", err, "pending", das.OutboxPending())
+			return
+		}
+		// events may have been recorded by others while the lock was held
+		das.mu.RLock()
+		_, ok := das.nextDue(false)
+		ok = ok && das.emitterFunc != nil
+		das.mu.RUnlock()
+		if !ok {
+			return
+		}
+	}
+}
+
+// hasEmitter reports whether an emitter is set.
+func (das *DevAccountService) hasEmitter() bool {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
+	return das.emitterFunc != nil
+}
+
+// RetryOutbox delivers the undelivered events whose retry is due.
+//
+// Delivery is retried whenever a new event is emitted; RetryOutbox may be
+// called periodically to also retry while no new events occur. It must not be
+// called by the emitter.
+func (das *DevAccountService) RetryOutbox(ctx context.Context) error {
+	das.outboxMu.Lock()
+	defer das.outboxMu.Unlock()
+	if !das.hasEmitter() {
+		return fmt.Errorf("no emitter set")
+	}
+	return das.deliverOutbox(ctx, false)
+}
+
+// DrainOutbox delivers all undelivered events now, regardless of their retry
+// schedule. It must not be called by the emitter.
+func (das *DevAccountService) DrainOutbox(ctx context.Context) error {
+	das.outboxMu.Lock()
+	defer das.outboxMu.Unlock()
+	if !das.hasEmitter() {
+		return fmt.Errorf("no emitter set")
+	}
+	return das.deliverOutbox(ctx, true)
+}
+
+// ReplayOutbox emits the recorded events from sequence number from onwards
+// again, including those already delivered. Only the events kept in the
+// outbox, as set with WithOutboxSize, can be replayed. It must not be called
+// by the emitter.
+func (das *DevAccountService) ReplayOutbox(ctx context.Context, from uint64) error {
+	var entries []outboxEntry
+
+	das.outboxMu.Lock()
+	defer das.outboxMu.Unlock()
+	das.mu.RLock()
+	fn := das.emitterFunc
+	for _, seq := range das.outboxSeqs() {
+		if seq >= from {
+			entries = append(entries, das.outbox[seq])
+		}
+	}
+	das.mu.RUnlock()
+	if fn == nil {
+		return fmt.Errorf("no emitter set")
+	}
+	for _, e := range entries {
+		err := fn(ctx, e.msg)
+		das.mu.Lock()
+		das.recordDelivery(ctx, e.Seq, err)
+		das.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("replay of event %d failed: %v", e.Seq, err)
+		}
+	}
+	return nil
+}
+
+// OutboxPending returns the number of recorded events not yet delivered.
+func (das *DevAccountService) OutboxPending() int {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
+	return das.outboxPending()
+}
+
+func (das *DevAccountService) outboxPending() int {
+	var c int
+
+	for _, e := range das.outbox {
+		if !e.Delivered {
+			c++
+		}
+	}
+	return c
+}
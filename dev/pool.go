@@ -0,0 +1,125 @@
+package dev
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+const (
+	// reserve of each voucher minted to a pool when it is registered
+	defaultPoolReserve int = 10000
+	// fee deducted from swap inputs, in basis points
+	defaultPoolFee int = 0
+	feeDenominator int = 10000
+)
+
+// WithPoolFee sets the swap fee, in basis points, applied to pools registered
+// after the call.
+func (das *DevAccountService) WithPoolFee(fee int) *DevAccountService {
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	das.poolFee = fee
+	return das
+}
+
+// SetPoolRate sets the exchange rate of a voucher in a pool, expressed as the
+// value of one unit of the voucher relative to the other vouchers. The default
+// rate is 1.
+func (das *DevAccountService) SetPoolRate(ctx context.Context, poolAddress string, tokenAddress string, rate float64) error {
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	p, ok := das.pools[poolAddress]
+	if !ok {
+		return remote.NewError(remote.ErrNotFound, "pool address %v not found", poolAddress)
+	}
+	if !p.hasVoucher(tokenAddress) {
+		return remote.NewError(remote.ErrValidation, "voucher with address %v not found in the pool", tokenAddress)
+	}
+	if rate <= 0 {
+		return remote.NewError(remote.ErrValidation, "invalid rate %f", rate)
+	}
+	if p.Rates == nil {
+		p.Rates = make(map[string]float64)
+	}
+	p.Rates[tokenAddress] = rate
+	err := das.savePoolInfo(ctx, p)
+	if err != nil {
+		return err
+	}
+	das.pools[poolAddress] = p
+	return nil
+}
+
+func (p *Pool) rate(voucherAddress string) float64 {
+	r, ok := p.Rates[voucherAddress]
+	if !ok || r <= 0 {
+		return 1
+	}
+	return r
+}
+
+// limit returns the maximum amount of the voucher that can be swapped into the pool at once.
+func (p *Pool) limit(voucherAddress string) (int, error) {
+	s, ok := p.PoolLimit[voucherAddress]
+	if !ok {
+		return 0, remote.NewError(remote.ErrValidation, "voucher with address %v not found in the pool", voucherAddress)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, remote.NewError(remote.ErrInternal, "invalid pool limit %q for %v", s, voucherAddress)
+	}
+	return int(v), nil
+}
+
+// reserve returns the pool balance of the voucher.
+func (das *DevAccountService) reserve(p Pool, voucherAddress string) int {
+	sym, ok := das.vouchersAddress[voucherAddress]
+	if !ok {
+		return 0
+	}
+	return das.accounts[p.Address].Balances[sym]
+}
+
+// quote returns the amount of the to voucher the pool pays out for value units
+// of the from voucher.
+//
+// The fee is deducted from the input, and the constant product formula applied
+// to it. The result is then converted at the ratio of the voucher rates. With
+// equal rates this is a plain constant product pool. Swaps that would empty
+// the to reserve are rejected.
+func (das *DevAccountService) quote(p Pool, value int, fromTokenAddress string, toTokenAddress string) (int, error) {
+	if fromTokenAddress == toTokenAddress {
+		return 0, remote.NewError(remote.ErrValidation, "cannot swap voucher %v with itself", fromTokenAddress)
+	}
+	if !p.hasVoucher(fromTokenAddress) {
+		return 0, remote.NewError(remote.ErrValidation, "voucher with address %v not found in the pool", fromTokenAddress)
+	}
+	if !p.hasVoucher(toTokenAddress) {
+		return 0, remote.NewError(remote.ErrValidation, "voucher with address %v not found in the pool", toTokenAddress)
+	}
+	if value <= 0 {
+		return 0, remote.NewError(remote.ErrValidation, "invalid amount %d", value)
+	}
+	reserveOut := das.reserve(p, toTokenAddress)
+	if reserveOut == 0 {
+		return 0, remote.NewError(remote.ErrValidation, "pool has no reserve of %v", toTokenAddress)
+	}
+
+	reserveIn := das.reserve(p, fromTokenAddress)
+	if reserveIn == 0 {
+		// the formula would pay out the whole to reserve for any amount
+		return 0, remote.NewError(remote.ErrValidation, "pool has no reserve of %v", fromTokenAddress)
+	}
+
+	x := float64(reserveIn)
+	y := float64(reserveOut)
+	dx := float64(value) * float64(feeDenominator-p.Fee) / float64(feeDenominator)
+	out := math.Floor(y * dx / (x + dx) * p.rate(fromTokenAddress) / p.rate(toTokenAddress))
+	if out >= y {
+		return 0, remote.NewError(remote.ErrValidation, "amount %d exceeds pool reserve of %v", value, toTokenAddress)
+	}
+	return int(out), nil
+}
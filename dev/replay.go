@@ -0,0 +1,96 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
+)
+
+// replayItem is an event reconstructed from stored state.
+type replayItem struct {
+	when time.Time
+	// accounts sort before txs at the same time, so that a registration
+	// precedes the mints it triggered.
+	order int
+	msg   event.Msg
+}
+
+// replayItems returns the events corresponding to the stored accounts and txs,
+// in chronological order.
+func (das *DevAccountService) replayItems() []replayItem {
+	var items []replayItem
+
+	for _, acc := range das.accounts {
+		if acc.Address == zeroAddress {
+			continue
+		}
+		items = append(items, replayItem{
+			when: acc.Created,
+			msg: event.Msg{
+				Typ:  event.EventRegistrationTag,
+				Item: acc.ToRegistrationEvent(),
+			},
+		})
+	}
+	for _, mytx := range das.txs {
+		if mytx.Reason != "" {
+			continue
+		}
+		msg := event.Msg{
+			Typ:  event.EventTokenTransferTag,
+			Item: das.transferEvent(mytx),
+		}
+		if mytx.Mint {
+			msg = event.Msg{
+				Typ:  event.EventTokenMintTag,
+				Item: das.mintEvent(mytx),
+			}
+		}
+		items = append(items, replayItem{
+			when:  mytx.When,
+			order: 1,
+			msg:   msg,
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].when.Equal(items[j].when) {
+			return items[i].when.Before(items[j].when)
+		}
+		return items[i].order < items[j].order
+	})
+	return items
+}
+
+// Replay emits the events of stored accounts and successful txs recorded at or
+// after since, in chronological order, to emitter.
+//
+// Registrations, mints and transfers are replayed; pool swaps and deposits are
+// replayed as the transfers they consist of. Accounts stored without a creation
+// time are only replayed when since is zero.
+//
+// Unlike ReplayOutbox, Replay does not depend on an emitter having been set when
+// the events occurred, and does not record anything in the outbox.
+func (das *DevAccountService) Replay(ctx context.Context, since time.Time, emitter event.EmitterFunc) error {
+	var c int
+
+	das.mu.RLock()
+	items := das.replayItems()
+	das.mu.RUnlock()
+	for _, item := range items {
+		if item.when.Before(since) {
+			continue
+		}
+		msg := item.msg
+		msg.Time = item.when
+		err := emitter(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("replay of %s event at %v failed: %v", msg.Typ, item.when, err)
+		}
+		c++
+	}
+	logg.DebugCtxf(ctx, "replayed events", "since", since, "count", c)
+	return nil
+}
@@ -0,0 +1,325 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
+	"git.grassecon.net/grassrootseconomics/visedriver/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// SeedVoucher describes a voucher to create.
+type SeedVoucher struct {
+	Symbol string `yaml:"symbol"`
+	// Name defaults to the symbol.
+	Name string `yaml:"name"`
+	// Address defaults to one derived from the symbol.
+	Address string `yaml:"address"`
+	// Decimals defaults to 6.
+	Decimals  *int   `yaml:"decimals"`
+	Sink      string `yaml:"sink"`
+	Commodity string `yaml:"commodity"`
+	Location  string `yaml:"location"`
+	// AutoValue, if set, is the amount given to every account created after
+	// the voucher, as with WithAutoVoucher. It is kept in storage.
+	AutoValue *int `yaml:"autoValue"`
+}
+
+// SeedAccount describes an account to create.
+type SeedAccount struct {
+	// Address defaults to a random one.
+	Address string `yaml:"address"`
+	// Alias is requested for the account as with RequestAlias, if set.
+	Alias string `yaml:"alias"`
+	// Balances are minted to the account, by voucher symbol.
+	Balances map[string]int `yaml:"balances"`
+}
+
+// SeedPool describes a pool to register.
+type SeedPool struct {
+	Name   string `yaml:"name"`
+	Symbol string `yaml:"symbol"`
+	// Vouchers are the symbols of the vouchers held by the pool.
+	Vouchers []string `yaml:"vouchers"`
+	// Limits are the swap limits by voucher symbol. Vouchers without a limit
+	// get the default.
+	Limits map[string]int `yaml:"limits"`
+	// Fee is the swap fee in basis points.
+	Fee int `yaml:"fee"`
+	// Rates are the exchange rates by voucher symbol, 1 if not set.
+	Rates map[string]float64 `yaml:"rates"`
+}
+
+// Seed describes the initial state of a DevAccountService.
+//
+// Vouchers are created first, then accounts, then pools, each in the order
+// given. The first account created becomes the account funding auto vouchers.
+type Seed struct {
+	Vouchers []SeedVoucher `yaml:"vouchers"`
+	Accounts []SeedAccount `yaml:"accounts"`
+	Pools    []SeedPool    `yaml:"pools"`
+}
+
+// ParseSeed reads a seed from YAML. As JSON is valid YAML, JSON seeds can be read too.
+func ParseSeed(r io.Reader) (*Seed, error) {
+	var seed Seed
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	err := dec.Decode(&seed)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("malformed seed: %v", err)
+	}
+	return &seed, nil
+}
+
+// NewDevAccountServiceFromSeed creates a DevAccountService like
+// NewDevAccountService, and applies the seed read from r to it.
+func NewDevAccountServiceFromSeed(ctx context.Context, ss storage.StorageService, r io.Reader) (*DevAccountService, error) {
+	seed, err := ParseSeed(r)
+	if err != nil {
+		return nil, err
+	}
+	svc := NewDevAccountService(ctx, ss)
+	err = svc.ApplySeed(ctx, seed)
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// ApplySeed creates the vouchers, accounts and pools of the seed.
+//
+// The seed is only applied to a service without vouchers, accounts and pools,
+// such as one on empty storage. Otherwise it is skipped, so that a service
+// restarted with the same seed keeps the state it has. The seed is checked as
+// a whole before anything is created.
+func (das *DevAccountService) ApplySeed(ctx context.Context, seed *Seed) error {
+	defer das.flushOutbox(ctx)
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	if len(das.vouchers) > 0 || len(das.pools) > 0 || len(das.accounts) > 1 {
+		logg.InfoCtxf(ctx, "not applying seed to existing state", "vouchers", len(das.vouchers), "accounts", len(das.accounts)-1, "pools", len(das.pools))
+		return nil
+	}
+	err := checkSeed(seed)
+	if err != nil {
+		return err
+	}
+	for _, sv := range seed.Vouchers {
+		err := das.seedVoucher(ctx, sv)
+		if err != nil {
+			return err
+		}
+	}
+	for i, sa := range seed.Accounts {
+		err := das.seedAccount(ctx, sa)
+		if err != nil {
+			return fmt.Errorf("seed account %d: %v", i, err)
+		}
+	}
+	for _, sp := range seed.Pools {
+		err := das.seedPool(ctx, sp)
+		if err != nil {
+			return fmt.Errorf("seed pool %s: %v", sp.Symbol, err)
+		}
+	}
+	logg.InfoCtxf(ctx, "applied seed", "vouchers", len(seed.Vouchers), "accounts", len(seed.Accounts), "pools", len(seed.Pools))
+	return nil
+}
+
+// checkSeed checks that the entities of the seed are unique, and that they
+// only refer to vouchers of the seed.
+func checkSeed(seed *Seed) error {
+	syms := make(map[string]bool)
+	for _, sv := range seed.Vouchers {
+		if sv.Symbol == "" {
+			return fmt.Errorf("seed voucher without symbol")
+		}
+		if syms[sv.Symbol] {
+			return fmt.Errorf("seed voucher %s given twice", sv.Symbol)
+		}
+		syms[sv.Symbol] = true
+	}
+	addresses := make(map[string]bool)
+	aliases := make(map[string]bool)
+	for i, sa := range seed.Accounts {
+		if sa.Address != "" {
+			if addresses[sa.Address] {
+				return fmt.Errorf("seed account %d: address %s given twice", i, sa.Address)
+			}
+			addresses[sa.Address] = true
+		}
+		if sa.Alias != "" {
+			if aliases[sa.Alias] {
+				return fmt.Errorf("seed account %d: alias %s given twice", i, sa.Alias)
+			}
+			aliases[sa.Alias] = true
+		}
+		for sym := range sa.Balances {
+			if !syms[sym] {
+				return fmt.Errorf("seed account %d: unknown voucher %s", i, sym)
+			}
+		}
+	}
+	pools := make(map[string]bool)
+	for _, sp := range seed.Pools {
+		if sp.Symbol == "" {
+			return fmt.Errorf("seed pool %s without symbol", sp.Name)
+		}
+		if pools[sp.Symbol] {
+			return fmt.Errorf("seed pool %s given twice", sp.Symbol)
+		}
+		pools[sp.Symbol] = true
+		held := make(map[string]bool)
+		for _, sym := range sp.Vouchers {
+			if !syms[sym] {
+				return fmt.Errorf("seed pool %s: unknown voucher %s", sp.Symbol, sym)
+			}
+			held[sym] = true
+		}
+		for sym := range sp.Limits {
+			if !held[sym] {
+				return fmt.Errorf("seed pool %s: limit for voucher %s not in pool", sp.Symbol, sym)
+			}
+		}
+		for sym := range sp.Rates {
+			if !held[sym] {
+				return fmt.Errorf("seed pool %s: rate for voucher %s not in pool", sp.Symbol, sym)
+			}
+		}
+	}
+	return nil
+}
+
+func (das *DevAccountService) seedVoucher(ctx context.Context, sv SeedVoucher) error {
+	v := Voucher{
+		Name:      sv.Name,
+		Symbol:    sv.Symbol,
+		Address:   sv.Address,
+		Decimals:  defaultDecimals,
+		Sink:      sv.Sink,
+		Commodity: sv.Commodity,
+		Location:  sv.Location,
+	}
+	if v.Name == "" {
+		v.Name = v.Symbol
+	}
+	if sv.Decimals != nil {
+		v.Decimals = *sv.Decimals
+	}
+	err := das.addVoucher(ctx, v)
+	if err != nil {
+		return err
+	}
+	if sv.AutoValue != nil {
+		err = das.saveAutoVoucher(ctx, v.Symbol, *sv.AutoValue)
+		if err != nil {
+			return err
+		}
+		das.setAutoVoucher(v.Symbol, *sv.AutoValue)
+	}
+	return nil
+}
+
+// voucherBySymbol returns the voucher with the given symbol.
+func (das *DevAccountService) voucherBySymbol(sym string) (Voucher, error) {
+	v, ok := das.vouchers[sym]
+	if !ok {
+		return v, fmt.Errorf("unknown voucher %s", sym)
+	}
+	return v, nil
+}
+
+func (das *DevAccountService) seedAccount(ctx context.Context, sa SeedAccount) error {
+	var pubKey string
+
+	if sa.Address == "" {
+		r, err := das.newAccount(ctx)
+		if err != nil {
+			return err
+		}
+		pubKey = r.PublicKey
+	} else {
+		_, ok := das.accounts[sa.Address]
+		if ok {
+			return fmt.Errorf("account %s already exists", sa.Address)
+		}
+		r, err := das.createAccount(ctx, sa.Address)
+		if err != nil {
+			return err
+		}
+		pubKey = r.PublicKey
+	}
+	// in symbol order, so that the txs are created in the same order every time
+	var syms []string
+	for sym := range sa.Balances {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	for _, sym := range syms {
+		v, err := das.voucherBySymbol(sym)
+		if err != nil {
+			return err
+		}
+		mytx, err := das.transfer(ctx, sa.Balances[sym], zeroAddress, pubKey, v.Address, true, "")
+		if err != nil {
+			return err
+		}
+		das.emit(ctx, event.EventTokenMintTag, das.mintEvent(mytx))
+	}
+	if sa.Alias != "" {
+		_, err := das.requestAlias(ctx, pubKey, sa.Alias)
+		if err != nil {
+			return err
+		}
+	}
+	logg.DebugCtxf(ctx, "seeded account", "address", pubKey, "alias", sa.Alias)
+	return nil
+}
+
+func (das *DevAccountService) seedPool(ctx context.Context, sp SeedPool) error {
+	var vouchers []Voucher
+
+	for _, sym := range sp.Vouchers {
+		v, err := das.voucherBySymbol(sym)
+		if err != nil {
+			return err
+		}
+		vouchers = append(vouchers, v)
+	}
+	p, err := das.registerPool(ctx, sp.Name, sp.Symbol, vouchers)
+	if err != nil {
+		return err
+	}
+	for sym, limit := range sp.Limits {
+		v, err := das.voucherBySymbol(sym)
+		if err != nil {
+			return err
+		}
+		if !p.hasVoucher(v.Address) {
+			return fmt.Errorf("limit for voucher %s not in pool", sym)
+		}
+		p.PoolLimit[v.Address] = fmt.Sprintf("%d", limit)
+	}
+	for sym, rate := range sp.Rates {
+		v, err := das.voucherBySymbol(sym)
+		if err != nil {
+			return err
+		}
+		if !p.hasVoucher(v.Address) {
+			return fmt.Errorf("rate for voucher %s not in pool", sym)
+		}
+		p.Rates[v.Address] = rate
+	}
+	p.Fee = sp.Fee
+	err = das.savePoolInfo(ctx, p)
+	if err != nil {
+		return err
+	}
+	das.pools[p.Address] = p
+	return nil
+}
@@ -0,0 +1,431 @@
+package dev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/config"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	"github.com/grassrootseconomics/eth-custodial/pkg/api"
+)
+
+type handlerFunc func(ctx context.Context, r *http.Request, params map[string]string) (any, error)
+
+type route struct {
+	method string
+	parts  []string
+	fn     handlerFunc
+}
+
+// match reports whether the request path matches the route, and returns the
+// values of the {name} path segments.
+func (rt *route) match(method string, parts []string) (map[string]string, bool) {
+	if method != rt.method || len(parts) != len(rt.parts) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, part := range rt.parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params[part[1:len(part)-1]] = parts[i]
+			continue
+		}
+		if part != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// Server exposes a DevAccountService over HTTP, on the same paths and with the
+// same eth-custodial response envelopes as the custodial, data indexer, alias,
+// SMS and M-Pesa onramp services.
+//
+// All upstream base URLs of a client may point to a single Server.
+type Server struct {
+	svc    *DevAccountService
+	routes []route
+}
+
+// NewServer creates a Server serving svc on the endpoint paths of cfg.
+func NewServer(svc *DevAccountService, cfg *config.Config) (*Server, error) {
+	srv := &Server{
+		svc: svc,
+	}
+	err := srv.register(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// add registers fn for the path of the endpoint URL ep, followed by the given path segments.
+//
+// Routes are matched in the order they are added.
+func (srv *Server) add(errs *[]error, method string, ep string, fn handlerFunc, elem ...string) {
+	u, err := url.Parse(ep)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("invalid endpoint %q: %v", ep, err))
+		return
+	}
+	parts := splitPath(u.Path)
+	parts = append(parts, elem...)
+	srv.routes = append(srv.routes, route{
+		method: method,
+		parts:  parts,
+		fn:     fn,
+	})
+}
+
+func (srv *Server) register(cfg *config.Config) error {
+	var errs []error
+
+	srv.add(&errs, "POST", cfg.CreateAccountURL, srv.createAccount)
+	srv.add(&errs, "GET", cfg.BalanceURL, srv.checkBalance, "{address}")
+	srv.add(&errs, "GET", cfg.TrackURL, srv.trackAccountStatus, "{address}")
+	srv.add(&errs, "GET", cfg.TrackStatusURL, srv.trackTransaction, "{trackingId}")
+	srv.add(&errs, "POST", cfg.TokenTransferURL, srv.tokenTransfer)
+	srv.add(&errs, "GET", cfg.VoucherHoldingsURL, srv.fetchVouchers, "{address}")
+	srv.add(&errs, "GET", cfg.VoucherTransfersURL, srv.fetchTransactions, "{address}")
+	srv.add(&errs, "GET", cfg.VoucherDataURL, srv.voucherData, "{address}")
+	srv.add(&errs, "POST", cfg.PoolDepositURL, srv.poolDeposit)
+	srv.add(&errs, "POST", cfg.PoolSwapQuoteURL, srv.poolSwapQuote)
+	srv.add(&errs, "POST", cfg.PoolSwapURL, srv.poolSwap)
+	srv.add(&errs, "GET", cfg.TopPoolsURL, srv.fetchTopPools)
+	srv.add(&errs, "GET", cfg.RetrievePoolDetailsURL, srv.retrievePoolDetails, "{symbol}")
+	srv.add(&errs, "GET", cfg.CreditSendReverseQuoteURL, srv.creditSendReverseQuote, "{pool}", "{from}", "{to}", "{amount}")
+	srv.add(&errs, "GET", cfg.PoolSwappableVouchersURL, srv.poolSwappableFromVouchers, "{pool}", "from", "{address}")
+	srv.add(&errs, "GET", cfg.PoolSwappableVouchersURL, srv.poolSwappableVouchers, "{pool}", "to")
+	srv.add(&errs, "GET", cfg.PoolSwappableVouchersURL, srv.swapFromTokenMaxLimit, "{pool}", "limit", "{from}", "{to}", "{address}")
+	srv.add(&errs, "GET", cfg.PoolSwappableVouchersURL, srv.checkTokenInPool, "{pool}", "check", "{token}")
+	srv.add(&errs, "GET", cfg.CreditSendURL, srv.creditSendMaxLimit, "{pool}", "{from}", "{to}", "{address}")
+	srv.add(&errs, "POST", cfg.AliasRegistrationURL, srv.requestAlias)
+	srv.add(&errs, "PUT", cfg.AliasUpdateURL, srv.updateAlias)
+	srv.add(&errs, "GET", cfg.AliasResolverURL, srv.checkAliasAddress, "{alias}")
+	srv.add(&errs, "POST", cfg.SendSMSURL, srv.sendUpsellSMS)
+	srv.add(&errs, "POST", cfg.ExternalSMSURL, srv.sendAddressSMS, "address")
+	srv.add(&errs, "POST", cfg.ExternalSMSURL, srv.sendPINResetSMS, "pinreset")
+	srv.add(&errs, "POST", cfg.MpesaOnrampURL, srv.mpesaTriggerOnramp)
+	srv.add(&errs, "GET", cfg.MpesaOnrampRatesURL, srv.mpesaOnrampRates)
+
+	return errors.Join(errs...)
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	parts := splitPath(r.URL.Path)
+	for _, rt := range srv.routes {
+		params, ok := rt.match(r.Method, parts)
+		if !ok {
+			continue
+		}
+		key := r.Header.Get(remote.IdempotencyKeyHeader)
+		if key != "" {
+			ctx = remote.WithIdempotencyKey(ctx, key)
+		}
+		v, err := rt.fn(ctx, r, params)
+		if err != nil {
+			logg.DebugCtxf(ctx, "dev server request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			writeError(w, err)
+			return
+		}
+		writeResult(w, v)
+		return
+	}
+	writeErrResponse(w, http.StatusNotFound, api.ErrNoRecordFound, fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path))
+}
+
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// writeResult writes v as the result of an eth-custodial OKResponse.
+func writeResult(w http.ResponseWriter, v any) {
+	var result map[string]any
+
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, &result)
+	}
+	if err != nil {
+		writeErrResponse(w, http.StatusInternalServerError, api.ErrCodeInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.OKResponse{
+		Ok:          true,
+		Description: "",
+		Result:      result,
+	})
+}
+
+// writeError writes err as an eth-custodial ErrResponse, with the status and
+// error code matching its remote error kind.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	code := api.ErrCodeInternalServerError
+	switch {
+	case errors.Is(err, remote.ErrNotFound):
+		status = http.StatusNotFound
+		code = api.ErrNoRecordFound
+	case errors.Is(err, remote.ErrInsufficientBalance), errors.Is(err, remote.ErrInvalidAlias), errors.Is(err, remote.ErrValidation):
+		status = http.StatusBadRequest
+		code = api.ErrCodeValidationFailed
+	case errors.Is(err, remote.ErrUnauthorized):
+		status = http.StatusUnauthorized
+		code = api.ErrCodeInvalidAPIKey
+	}
+	writeErrResponse(w, status, code, err.Error())
+}
+
+func writeErrResponse(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.ErrResponse{
+		Ok:          false,
+		Description: description,
+		ErrCode:     code,
+	})
+}
+
+// decode reads the JSON request body into v.
+func decode(r *http.Request, v any) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		return remote.NewError(remote.ErrValidation, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (srv *Server) createAccount(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.CreateAccount(ctx)
+}
+
+func (srv *Server) checkBalance(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.CheckBalance(ctx, params["address"])
+}
+
+func (srv *Server) trackAccountStatus(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.TrackAccountStatus(ctx, params["address"])
+}
+
+func (srv *Server) trackTransaction(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.TrackTransaction(ctx, params["trackingId"])
+}
+
+func (srv *Server) tokenTransfer(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req api.TransferRequest
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.TokenTransfer(ctx, req.Amount, req.From, req.To, req.TokenAddress)
+}
+
+func (srv *Server) fetchVouchers(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	holdings, err := srv.svc.FetchVouchers(ctx, params["address"])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"holdings": holdings}, nil
+}
+
+func (srv *Server) fetchTransactions(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	transfers, err := srv.svc.FetchTransactions(ctx, params["address"])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"transfers": transfers}, nil
+}
+
+func (srv *Server) voucherData(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	details, err := srv.svc.VoucherData(ctx, params["address"])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"tokenDetails": details}, nil
+}
+
+func (srv *Server) poolDeposit(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req api.PoolDepositRequest
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.PoolDeposit(ctx, req.Amount, req.From, req.PoolAddress, req.TokenAddress)
+}
+
+func (srv *Server) poolSwapQuote(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req api.PoolSwapRequest
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.GetPoolSwapQuote(ctx, req.Amount, req.From, req.FromTokenAddress, req.PoolAddress, req.ToTokenAddress)
+}
+
+func (srv *Server) poolSwap(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req api.PoolSwapRequest
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.PoolSwap(ctx, req.Amount, req.From, req.FromTokenAddress, req.PoolAddress, req.ToTokenAddress)
+}
+
+func (srv *Server) fetchTopPools(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	pools, err := srv.svc.FetchTopPools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"topPools": pools}, nil
+}
+
+func (srv *Server) retrievePoolDetails(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	details, err := srv.svc.RetrievePoolDetails(ctx, params["symbol"])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"poolDetails": details}, nil
+}
+
+func (srv *Server) poolSwappableFromVouchers(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	vouchers, err := srv.svc.GetPoolSwappableFromVouchers(ctx, params["pool"], params["address"])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"filtered": vouchers}, nil
+}
+
+func (srv *Server) poolSwappableVouchers(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	vouchers, err := srv.svc.GetPoolSwappableVouchers(ctx, params["pool"])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"filtered": vouchers}, nil
+}
+
+func (srv *Server) swapFromTokenMaxLimit(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.GetSwapFromTokenMaxLimit(ctx, params["pool"], params["from"], params["to"], params["address"])
+}
+
+func (srv *Server) checkTokenInPool(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.CheckTokenInPool(ctx, params["pool"], params["token"])
+}
+
+func (srv *Server) creditSendMaxLimit(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.GetCreditSendMaxLimit(ctx, params["pool"], params["from"], params["to"], params["address"])
+}
+
+func (srv *Server) creditSendReverseQuote(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.GetCreditSendReverseQuote(ctx, params["pool"], params["from"], params["to"], params["amount"])
+}
+
+func (srv *Server) requestAlias(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req struct {
+		Address string `json:"address"`
+		Hint    string `json:"hint"`
+	}
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	hint := strings.TrimSuffix(req.Hint, ".sarafu.eth")
+	res, err := srv.svc.RequestAlias(ctx, req.Address, hint)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"address": req.Address, "name": res.Alias}, nil
+}
+
+func (srv *Server) updateAlias(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req struct {
+		Address string `json:"address"`
+		Name    string `json:"name"`
+	}
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := srv.svc.UpdateAlias(ctx, req.Address, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"address": req.Address, "name": res.Alias}, nil
+}
+
+func (srv *Server) checkAliasAddress(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.CheckAliasAddress(ctx, params["alias"])
+}
+
+func (srv *Server) sendUpsellSMS(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req struct {
+		InviterPhone string `json:"inviterPhone"`
+		InviteePhone string `json:"inviteePhone"`
+	}
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.SendUpsellSMS(ctx, req.InviterPhone, req.InviteePhone)
+}
+
+func (srv *Server) sendAddressSMS(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req struct {
+		Address     string `json:"address"`
+		OriginPhone string `json:"originPhone"`
+	}
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	err = srv.svc.SendAddressSMS(ctx, req.Address, req.OriginPhone)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"address": req.Address}, nil
+}
+
+func (srv *Server) sendPINResetSMS(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req struct {
+		Admin string `json:"admin"`
+		Phone string `json:"phone"`
+	}
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	err = srv.svc.SendPINResetSMS(ctx, req.Admin, req.Phone)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"phone": req.Phone}, nil
+}
+
+func (srv *Server) mpesaTriggerOnramp(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	var req struct {
+		Address     string `json:"address"`
+		PhoneNumber string `json:"phoneNumber"`
+		Asset       string `json:"asset"`
+		Amount      int    `json:"amount"`
+	}
+	err := decode(r, &req)
+	if err != nil {
+		return nil, err
+	}
+	return srv.svc.MpesaTriggerOnramp(ctx, req.Address, req.PhoneNumber, req.Asset, req.Amount)
+}
+
+func (srv *Server) mpesaOnrampRates(ctx context.Context, r *http.Request, params map[string]string) (any, error) {
+	return srv.svc.GetMpesaOnrampRates(ctx)
+}
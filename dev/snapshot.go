@@ -0,0 +1,319 @@
+package dev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// SnapshotVersion is the version of the document written by Export.
+const SnapshotVersion = 1
+
+// snapshot is the document written by Export and read by Import.
+//
+// It has types of its own rather than those of the service, so that the
+// document does not change with how the service stores its state.
+type snapshot struct {
+	Version        int               `json:"version"`
+	DefaultAccount string            `json:"defaultAccount"`
+	Vouchers       []snapshotVoucher `json:"vouchers"`
+	Accounts       []snapshotAccount `json:"accounts"`
+	Aliases        map[string]string `json:"aliases"`
+	Pools          []snapshotPool    `json:"pools"`
+	Txs            []snapshotTx      `json:"txs"`
+}
+
+type snapshotVoucher struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Symbol    string `json:"symbol"`
+	Decimals  int    `json:"decimals"`
+	Sink      string `json:"sink"`
+	Commodity string `json:"commodity"`
+	Location  string `json:"location"`
+}
+
+func newSnapshotVoucher(v Voucher) snapshotVoucher {
+	return snapshotVoucher(v)
+}
+
+func (v snapshotVoucher) voucher() Voucher {
+	return Voucher(v)
+}
+
+type snapshotAccount struct {
+	Track          string         `json:"track"`
+	Address        string         `json:"address"`
+	Nonce          int            `json:"nonce"`
+	DefaultVoucher string         `json:"defaultVoucher"`
+	Balances       map[string]int `json:"balances"`
+	Alias          string         `json:"alias"`
+	Txs            []string       `json:"txs"`
+	Created        time.Time      `json:"created"`
+}
+
+func newSnapshotAccount(acc Account) snapshotAccount {
+	return snapshotAccount(acc)
+}
+
+func (acc snapshotAccount) account() Account {
+	return Account(acc)
+}
+
+type snapshotPool struct {
+	Name      string             `json:"name"`
+	Symbol    string             `json:"symbol"`
+	Address   string             `json:"address"`
+	Vouchers  []snapshotVoucher  `json:"vouchers"`
+	PoolLimit map[string]string  `json:"poolLimit"`
+	Fee       int                `json:"fee"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+func newSnapshotPool(p Pool) snapshotPool {
+	sp := snapshotPool{
+		Name:      p.Name,
+		Symbol:    p.Symbol,
+		Address:   p.Address,
+		Vouchers:  []snapshotVoucher{},
+		PoolLimit: p.PoolLimit,
+		Fee:       p.Fee,
+		Rates:     p.Rates,
+	}
+	for _, v := range p.Vouchers {
+		sp.Vouchers = append(sp.Vouchers, newSnapshotVoucher(v))
+	}
+	return sp
+}
+
+func (sp snapshotPool) pool() Pool {
+	p := Pool{
+		Name:      sp.Name,
+		Symbol:    sp.Symbol,
+		Address:   sp.Address,
+		PoolLimit: sp.PoolLimit,
+		Fee:       sp.Fee,
+		Rates:     sp.Rates,
+	}
+	for _, v := range sp.Vouchers {
+		p.Vouchers = append(p.Vouchers, v.voucher())
+	}
+	return p
+}
+
+type snapshotTx struct {
+	Track   string    `json:"track"`
+	Hsh     string    `json:"hash"`
+	To      string    `json:"to"`
+	From    string    `json:"from"`
+	Voucher string    `json:"voucher"`
+	Value   int       `json:"value"`
+	When    time.Time `json:"when"`
+	Reason  string    `json:"reason,omitempty"`
+	Mint    bool      `json:"mint,omitempty"`
+}
+
+func newSnapshotTx(mytx Tx) snapshotTx {
+	return snapshotTx(mytx)
+}
+
+func (mytx snapshotTx) tx() Tx {
+	return Tx(mytx)
+}
+
+// Export writes the vouchers, accounts with their balances, aliases, pools and
+// txs of the service to w as a single JSON document.
+//
+// The document is sorted, so that exporting the same state always gives the same output.
+func (das *DevAccountService) Export(ctx context.Context, w io.Writer) error {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
+	snap := snapshot{
+		Version:        SnapshotVersion,
+		DefaultAccount: das.defaultAccount,
+		Vouchers:       []snapshotVoucher{},
+		Accounts:       []snapshotAccount{},
+		Aliases:        make(map[string]string),
+		Pools:          []snapshotPool{},
+		Txs:            []snapshotTx{},
+	}
+	for _, v := range das.vouchers {
+		snap.Vouchers = append(snap.Vouchers, newSnapshotVoucher(v))
+	}
+	sort.Slice(snap.Vouchers, func(i, j int) bool {
+		return snap.Vouchers[i].Symbol < snap.Vouchers[j].Symbol
+	})
+	for _, acc := range das.accounts {
+		if acc.Address == zeroAddress {
+			continue
+		}
+		snap.Accounts = append(snap.Accounts, newSnapshotAccount(acc))
+	}
+	sort.Slice(snap.Accounts, func(i, j int) bool {
+		return snap.Accounts[i].Address < snap.Accounts[j].Address
+	})
+	for k, v := range das.accountsAlias {
+		snap.Aliases[k] = v
+	}
+	for _, p := range das.pools {
+		snap.Pools = append(snap.Pools, newSnapshotPool(p))
+	}
+	sort.Slice(snap.Pools, func(i, j int) bool {
+		return snap.Pools[i].Address < snap.Pools[j].Address
+	})
+	for _, mytx := range das.txs {
+		snap.Txs = append(snap.Txs, newSnapshotTx(mytx))
+	}
+	sort.Slice(snap.Txs, func(i, j int) bool {
+		if !snap.Txs[i].When.Equal(snap.Txs[j].When) {
+			return snap.Txs[i].When.Before(snap.Txs[j].When)
+		}
+		return snap.Txs[i].Hsh < snap.Txs[j].Hsh
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(snap)
+	if err != nil {
+		return err
+	}
+	logg.DebugCtxf(ctx, "exported snapshot", "accounts", len(snap.Accounts), "txs", len(snap.Txs))
+	return nil
+}
+
+// Import replaces the vouchers, accounts, aliases, pools and txs of the service
+// with those of a document written by Export, and stores them in the db.
+//
+// The snapshot is checked, and the service is left unchanged if it is invalid
+// or cannot be stored. Entries already in the db are not removed, so Import
+// should be used on a service with empty storage.
+func (das *DevAccountService) Import(ctx context.Context, r io.Reader) error {
+	var snap snapshot
+
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	err := json.NewDecoder(r).Decode(&snap)
+	if err != nil {
+		return fmt.Errorf("malformed snapshot: %v", err)
+	}
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", snap.Version)
+	}
+	// the new state is built and checked first, and replaces the current one
+	// only once it has been stored
+	vouchers := make(map[string]Voucher)
+	vouchersAddress := make(map[string]string)
+	for _, sv := range snap.Vouchers {
+		v := sv.voucher()
+		vouchers[v.Symbol] = v
+		vouchersAddress[v.Address] = v.Symbol
+	}
+	accounts := map[string]Account{
+		zeroAddress: Account{
+			Address: zeroAddress,
+		},
+	}
+	accountsTrack := make(map[string]string)
+	for _, sacc := range snap.Accounts {
+		acc := sacc.account()
+		for sym := range acc.Balances {
+			_, ok := vouchers[sym]
+			if !ok {
+				return fmt.Errorf("account %s has unknown voucher %s", acc.Address, sym)
+			}
+		}
+		accounts[acc.Address] = acc
+		if acc.Track != "" {
+			accountsTrack[acc.Track] = acc.Address
+		}
+	}
+	accountsAlias := make(map[string]string)
+	for k, v := range snap.Aliases {
+		_, ok := accounts[v]
+		if !ok {
+			return fmt.Errorf("alias %s has unknown account %s", k, v)
+		}
+		accountsAlias[k] = v
+	}
+	pools := make(map[string]Pool)
+	for _, sp := range snap.Pools {
+		p := sp.pool()
+		for _, v := range p.Vouchers {
+			_, ok := vouchers[v.Symbol]
+			if !ok {
+				return fmt.Errorf("pool %s has unknown voucher %s", p.Symbol, v.Symbol)
+			}
+		}
+		pools[p.Address] = p
+	}
+	txs := make(map[string]Tx)
+	txsTrack := make(map[string]string)
+	for _, stx := range snap.Txs {
+		mytx := stx.tx()
+		_, ok := vouchers[mytx.Voucher]
+		if !ok {
+			return fmt.Errorf("tx %s has unknown voucher %s", mytx.Hsh, mytx.Voucher)
+		}
+		txs[mytx.Hsh] = mytx
+		txsTrack[mytx.Track] = mytx.Hsh
+	}
+	defaultAccount := snap.DefaultAccount
+	if defaultAccount == "" {
+		defaultAccount = zeroAddress
+	}
+	_, ok := accounts[defaultAccount]
+	if !ok {
+		return fmt.Errorf("unknown default account %s", defaultAccount)
+	}
+
+	for _, v := range vouchers {
+		err = das.saveVoucher(ctx, v)
+		if err != nil {
+			return err
+		}
+	}
+	for _, acc := range accounts {
+		if acc.Address == zeroAddress {
+			continue
+		}
+		err = das.saveAccount(ctx, acc)
+		if err != nil {
+			return err
+		}
+	}
+	if das.db != nil {
+		for k, v := range accountsAlias {
+			err = das.saveAlias(ctx, map[string]string{k: v})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range pools {
+		err = das.savePoolInfo(ctx, p)
+		if err != nil {
+			return err
+		}
+	}
+	for _, mytx := range txs {
+		err = das.saveTokenTransfer(ctx, mytx)
+		if err != nil {
+			return err
+		}
+	}
+
+	das.vouchers = vouchers
+	das.vouchersAddress = vouchersAddress
+	das.accounts = accounts
+	das.accountsTrack = accountsTrack
+	das.accountsAlias = accountsAlias
+	das.pools = pools
+	das.txs = txs
+	das.txsTrack = txsTrack
+	das.defaultAccount = defaultAccount
+	logg.DebugCtxf(ctx, "imported snapshot", "accounts", len(snap.Accounts), "txs", len(snap.Txs))
+	return nil
+}
@@ -0,0 +1,78 @@
+package dev
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+const simulatedFailureReason = "simulated failure"
+
+// WithConfirmDelay sets how long transactions take to be confirmed.
+//
+// A transaction is reported as pending for the first half of the delay, and as
+// submitted for the second half. Balances are moved immediately regardless.
+func (das *DevAccountService) WithConfirmDelay(d time.Duration) *DevAccountService {
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	das.confirmDelay = d
+	return das
+}
+
+// WithFailureRate sets the share of transfers, swaps and deposits, between 0
+// and 1, that fail after their confirmation delay without moving any balances.
+func (das *DevAccountService) WithFailureRate(rate float64) *DevAccountService {
+	das.mu.Lock()
+	defer das.mu.Unlock()
+	das.failureRate = rate
+	return das
+}
+
+// failure returns the failure reason for a new transaction, or an empty string
+// if it is to succeed.
+func (das *DevAccountService) failure() string {
+	if das.failureRate <= 0 || rand.Float64() >= das.failureRate {
+		return ""
+	}
+	return simulatedFailureReason
+}
+
+func (das *DevAccountService) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	das.mu.RLock()
+	defer das.mu.RUnlock()
+	hsh, ok := das.txsTrack[trackingId]
+	if !ok {
+		return nil, remote.NewError(remote.ErrNotFound, "tracking id %v not found", trackingId)
+	}
+	mytx, ok := das.txs[hsh]
+	if !ok {
+		return nil, remote.NewError(remote.ErrInternal, "tracking id %v found but tx %v does not resolve", trackingId, hsh)
+	}
+
+	age := time.Since(mytx.When)
+	if age < das.confirmDelay/2 {
+		return &models.TrackTransactionResult{
+			Status: models.TxStatusPending,
+		}, nil
+	}
+	if age < das.confirmDelay {
+		return &models.TrackTransactionResult{
+			Status: models.TxStatusSubmitted,
+			TxHash: mytx.Hsh,
+		}, nil
+	}
+	if mytx.Reason != "" {
+		return &models.TrackTransactionResult{
+			Status:        models.TxStatusFailed,
+			TxHash:        mytx.Hsh,
+			FailureReason: mytx.Reason,
+		}, nil
+	}
+	return &models.TrackTransactionResult{
+		Status: models.TxStatusConfirmed,
+		TxHash: mytx.Hsh,
+	}, nil
+}
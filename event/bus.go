@@ -0,0 +1,286 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("sarafu-api.event")
+)
+
+// Wildcard subscribes to events of all tags.
+const Wildcard = "*"
+
+// DefaultQueueSize is the number of events a subscriber can have queued
+// before the back-pressure policy applies.
+const DefaultQueueSize = 64
+
+var (
+	// ErrBusClosed is returned when emitting to or subscribing on a closed Bus.
+	ErrBusClosed = errors.New("event bus closed")
+	// ErrQueueFull is returned by Bus.Emit for subscribers with the Fail policy whose queue is full.
+	ErrQueueFull = errors.New("subscriber queue full")
+)
+
+// Policy decides what Bus.Emit does when a subscriber queue is full.
+type Policy int
+
+const (
+	// Block waits until the subscriber has room, or the emit context is done.
+	Block Policy = iota
+	// Drop discards the event for that subscriber.
+	Drop
+	// Fail discards the event for that subscriber and makes Emit return ErrQueueFull.
+	Fail
+)
+
+type envelope struct {
+	ctx context.Context
+	msg Msg
+}
+
+// Subscription is a handler receiving events of one tag, or of all tags, in
+// its own goroutine.
+type Subscription struct {
+	bus    *Bus
+	tag    string
+	fn     EmitterFunc
+	policy Policy
+	queue  chan envelope
+	// done is closed when the subscription stops accepting events. The queue
+	// itself is never closed, as Emit may still be pushing to it.
+	done chan struct{}
+	// err is returned by pushes after done is closed.
+	err  error
+	once sync.Once
+	// pushMu is held for reading by pushes, so that run drains the queue only
+	// once no push can add to it anymore.
+	pushMu  sync.RWMutex
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of events discarded because the queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops delivery of new events to the subscription. Events
+// already queued are still handled.
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	subs := s.bus.subs[s.tag]
+	for i, sub := range subs {
+		if sub == s {
+			s.bus.subs[s.tag] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	s.close(nil)
+}
+
+// close stops the subscription accepting events. Later pushes return err.
+func (s *Subscription) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+func (s *Subscription) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case env := <-s.queue:
+			s.handle(env)
+		case <-s.done:
+			// wait for running pushes, which end on done
+			s.pushMu.Lock()
+			s.pushMu.Unlock()
+			for {
+				select {
+				case env := <-s.queue:
+					s.handle(env)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Subscription) handle(env envelope) {
+	err := s.fn(env.ctx, env.msg)
+	if err != nil {
+		logg.ErrorCtxf(env.ctx, "event subscriber failed", "tag", env.msg.Typ, "err", err)
+	}
+}
+
+// push queues an event according to the subscription policy. Events for a
+// closed subscription are discarded, and the error it was closed with is
+// returned.
+func (s *Subscription) push(ctx context.Context, env envelope) error {
+	s.pushMu.RLock()
+	defer s.pushMu.RUnlock()
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	if s.policy == Block {
+		select {
+		case s.queue <- env:
+			return nil
+		case <-s.done:
+			return s.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case s.queue <- env:
+		return nil
+	default:
+	}
+	s.dropped.Add(1)
+	logg.WarnCtxf(ctx, "event subscriber queue full, dropping event", "tag", env.msg.Typ, "subscription", s.tag)
+	if s.policy == Fail {
+		return fmt.Errorf("%w: %s", ErrQueueFull, s.tag)
+	}
+	return nil
+}
+
+// Bus delivers events to any number of subscribers per tag.
+//
+// Each subscriber has a bounded queue and its own goroutine, so a slow
+// subscriber only affects others through the Block policy.
+type Bus struct {
+	mu        sync.RWMutex
+	wg        sync.WaitGroup
+	subs      map[string][]*Subscription
+	closed    bool
+	queueSize int
+	policy    Policy
+}
+
+// NewBus creates a Bus whose subscribers use DefaultQueueSize and the Block policy.
+func NewBus() *Bus {
+	return &Bus{
+		subs:      make(map[string][]*Subscription),
+		queueSize: DefaultQueueSize,
+		policy:    Block,
+	}
+}
+
+// WithQueueSize sets the queue size of subscriptions created by Subscribe.
+func (b *Bus) WithQueueSize(size int) *Bus {
+	b.queueSize = size
+	return b
+}
+
+// WithPolicy sets the back-pressure policy of subscriptions created by Subscribe.
+func (b *Bus) WithPolicy(policy Policy) *Bus {
+	b.policy = policy
+	return b
+}
+
+// Subscribe registers fn for events with the given tag, or for all events if
+// tag is Wildcard, using the queue size and policy of the Bus.
+func (b *Bus) Subscribe(tag string, fn EmitterFunc) (*Subscription, error) {
+	return b.SubscribeQueue(tag, fn, b.queueSize, b.policy)
+}
+
+// SubscribeQueue registers fn like Subscribe, with its own queue size and policy.
+func (b *Bus) SubscribeQueue(tag string, fn EmitterFunc, size int, policy Policy) (*Subscription, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid queue size %d", size)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+	s := &Subscription{
+		bus:    b,
+		tag:    tag,
+		fn:     fn,
+		policy: policy,
+		queue:  make(chan envelope, size),
+		done:   make(chan struct{}),
+	}
+	b.subs[tag] = append(b.subs[tag], s)
+	b.wg.Add(1)
+	go s.run(&b.wg)
+	return s, nil
+}
+
+// Emit queues msg for every subscriber of its tag and every wildcard
+// subscriber. It has the signature of an EmitterFunc.
+//
+// Handlers run with the values of ctx, but are not cancelled with it.
+//
+// The subscribers are those at the time of the call. Events are queued without
+// holding the lock of the Bus, so that an emit blocked on a full queue does not
+// keep handlers from subscribing, unsubscribing or emitting. An event emitted
+// while the Bus is closing is either handled, or Emit returns ErrBusClosed.
+func (b *Bus) Emit(ctx context.Context, msg Msg) error {
+	var errs []error
+	var subs []*Subscription
+
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrBusClosed
+	}
+	subs = append(subs, b.subs[msg.Typ]...)
+	if msg.Typ != Wildcard {
+		subs = append(subs, b.subs[Wildcard]...)
+	}
+	b.mu.RUnlock()
+
+	env := envelope{
+		ctx: context.WithoutCancel(ctx),
+		msg: msg,
+	}
+	for _, s := range subs {
+		err := s.push(ctx, env)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops accepting events and waits until all queued events have been
+// handled, or until ctx is done.
+func (b *Bus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, subs := range b.subs {
+			for _, s := range subs {
+				s.close(ErrBusClosed)
+			}
+		}
+		b.subs = make(map[string][]*Subscription)
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
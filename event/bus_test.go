@@ -0,0 +1,184 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBus(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[string][]string)
+	record := func(name string) EmitterFunc {
+		return func(ctx context.Context, msg Msg) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[name] = append(got[name], msg.Typ)
+			return nil
+		}
+	}
+	ctx := context.Background()
+	bus := NewBus()
+	for _, name := range []string{"notify", "ledger"} {
+		_, err := bus.Subscribe(EventTokenTransferTag, record(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := bus.Subscribe(Wildcard, record("analytics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []string{EventTokenTransferTag, EventRegistrationTag, EventTokenTransferTag} {
+		err = bus.Emit(ctx, Msg{Typ: tag})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = bus.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got["notify"]) != 2 || len(got["ledger"]) != 2 || len(got["analytics"]) != 3 {
+		t.Fatalf("unexpected deliveries %v", got)
+	}
+	err = bus.Emit(ctx, Msg{Typ: EventTokenTransferTag})
+	if !errors.Is(err, ErrBusClosed) {
+		t.Fatalf("expected bus closed, got %v", err)
+	}
+}
+
+func TestBusPolicy(t *testing.T) {
+	release := make(chan struct{})
+	slow := func(ctx context.Context, msg Msg) error {
+		<-release
+		return nil
+	}
+	ctx := context.Background()
+	bus := NewBus()
+	drop, err := bus.SubscribeQueue(EventTokenTransferTag, slow, 1, Drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail, err := bus.SubscribeQueue(EventTokenMintTag, slow, 1, Fail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first event may be taken by the handler or sit in the queue, the
+	// third cannot fit either way
+	for i := 0; i < 3; i++ {
+		err = bus.Emit(ctx, Msg{Typ: EventTokenTransferTag})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if drop.Dropped() == 0 {
+		t.Fatalf("expected dropped events")
+	}
+	for i := 0; i < 3 && err == nil; i++ {
+		err = bus.Emit(ctx, Msg{Typ: EventTokenMintTag})
+	}
+	if !errors.Is(err, ErrQueueFull) || fail.Dropped() != 1 {
+		t.Fatalf("expected queue full, got %v", err)
+	}
+	close(release)
+	err = bus.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBusBlockedEmit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	slow := func(ctx context.Context, msg Msg) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	ctx := context.Background()
+	bus := NewBus()
+	blocked, err := bus.SubscribeQueue(Wildcard, slow, 1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one event is handled, one queued, and the third blocks
+	emitted := make(chan error)
+	go func() {
+		for i := 0; i < 3; i++ {
+			err := bus.Emit(ctx, Msg{Typ: EventTokenTransferTag})
+			if err != nil {
+				emitted <- err
+				return
+			}
+		}
+		emitted <- nil
+	}()
+
+	<-started
+	for len(blocked.queue) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the bus can be used while an emit is blocked
+	sub, err := bus.Subscribe(EventRegistrationTag, func(ctx context.Context, msg Msg) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Unsubscribe()
+
+	close(release)
+	err = <-emitted
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bus.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBusEmitClose(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		var handled atomic.Int32
+		ctx := context.Background()
+		bus := NewBus()
+		_, err := bus.Subscribe(Wildcard, func(ctx context.Context, msg Msg) error {
+			handled.Add(1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var accepted atomic.Int32
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := bus.Emit(ctx, Msg{Typ: EventTokenTransferTag})
+				if err == nil {
+					accepted.Add(1)
+				} else if !errors.Is(err, ErrBusClosed) {
+					t.Error(err)
+				}
+			}()
+		}
+		err = bus.Close(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		if handled.Load() != accepted.Load() {
+			t.Fatalf("accepted %d events, handled %d", accepted.Load(), handled.Load())
+		}
+	}
+}
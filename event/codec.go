@@ -0,0 +1,138 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// SchemaVersion is the version of the wire format written by the codecs.
+const SchemaVersion = 1
+
+var (
+	// ErrUnknownType is returned when decoding an event whose type tag has no registered item type.
+	ErrUnknownType = errors.New("unknown event type")
+	// ErrUnsupportedVersion is returned when decoding an event without a schema version, or written with a newer one.
+	ErrUnsupportedVersion = errors.New("unsupported event schema version")
+)
+
+var (
+	typesMu sync.RWMutex
+	types   = map[string]reflect.Type{
+		EventTokenTransferTag: reflect.TypeOf(EventTokenTransfer{}),
+		EventTokenMintTag:     reflect.TypeOf(EventTokenMint{}),
+		EventRegistrationTag:  reflect.TypeOf(EventCustodialRegistration{}),
+		EventPoolSwapTag:      reflect.TypeOf(EventPoolSwap{}),
+		EventPoolDepositTag:   reflect.TypeOf(EventPoolDeposit{}),
+		EventAliasTag:         reflect.TypeOf(EventAlias{}),
+	}
+)
+
+// RegisterType sets the type that items of events with the given tag are
+// decoded into. item is an example value of that type.
+func RegisterType(tag string, item any) {
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	types[tag] = reflect.TypeOf(item)
+}
+
+func typeFor(tag string) (reflect.Type, bool) {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	t, ok := types[tag]
+	return t, ok
+}
+
+// Codec serializes a Msg for use across process boundaries.
+type Codec interface {
+	// Encode serializes msg, setting its Id and Time if they are empty.
+	Encode(msg Msg) ([]byte, error)
+	// Decode restores a Msg, with its Item as the type registered for its tag.
+	Decode(b []byte) (Msg, error)
+	// ContentType is the MIME type of the serialized form.
+	ContentType() string
+}
+
+// stamp sets the id and timestamp of msg if they are empty.
+func stamp(msg Msg) (Msg, error) {
+	if msg.Id == "" {
+		uid, err := uuid.NewV4()
+		if err != nil {
+			return msg, err
+		}
+		msg.Id = uid.String()
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now().UTC()
+	}
+	return msg, nil
+}
+
+type jsonEnvelope struct {
+	Typ     string          `json:"type"`
+	Version int             `json:"version"`
+	Id      string          `json:"id"`
+	Time    time.Time       `json:"timestamp"`
+	Item    json.RawMessage `json:"item"`
+}
+
+type jsonCodec struct{}
+
+// JSON encodes events as a JSON envelope of the form
+//
+//	{"type": "TOKEN_TRANSFER", "version": 1, "id": "...", "timestamp": "...", "item": {...}}
+var JSON Codec = jsonCodec{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Encode(msg Msg) ([]byte, error) {
+	msg, err := stamp(msg)
+	if err != nil {
+		return nil, err
+	}
+	item, err := json.Marshal(msg.Item)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode %s item: %v", msg.Typ, err)
+	}
+	return json.Marshal(jsonEnvelope{
+		Typ:     msg.Typ,
+		Version: SchemaVersion,
+		Id:      msg.Id,
+		Time:    msg.Time,
+		Item:    item,
+	})
+}
+
+func (jsonCodec) Decode(b []byte) (Msg, error) {
+	var env jsonEnvelope
+	var msg Msg
+
+	err := json.Unmarshal(b, &env)
+	if err != nil {
+		return msg, fmt.Errorf("malformed event envelope: %v", err)
+	}
+	if env.Version < 1 || env.Version > SchemaVersion {
+		return msg, fmt.Errorf("%w: %d", ErrUnsupportedVersion, env.Version)
+	}
+	t, ok := typeFor(env.Typ)
+	if !ok {
+		return msg, fmt.Errorf("%w: %s", ErrUnknownType, env.Typ)
+	}
+	v := reflect.New(t)
+	err = json.Unmarshal(env.Item, v.Interface())
+	if err != nil {
+		return msg, fmt.Errorf("malformed %s item: %v", env.Typ, err)
+	}
+	msg.Typ = env.Typ
+	msg.Id = env.Id
+	msg.Time = env.Time
+	msg.Item = v.Elem().Interface()
+	return msg, nil
+}
@@ -0,0 +1,56 @@
+package event
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestJSONCodec(t *testing.T) {
+	ev := EventTokenTransfer{
+		To:             "0xB3117202371853e24B725d4169D87616A7dDb127",
+		From:           "0xeae046BF396e91f5A8D74f863dC57c107c8a4a70",
+		Value:          42,
+		VoucherAddress: "0x765DE816845861e75A25fCA122bb6898B8B1282a",
+		TxHash:         "0xabcd",
+	}
+	b, err := JSON.Encode(Msg{Typ: EventTokenTransferTag, Item: ev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// items keep the field names they had before the codec
+	if !bytes.Contains(b, []byte(`"TxHash":"0xabcd"`)) {
+		t.Fatalf("expected field name keys, got %s", b)
+	}
+	b2, err := JSON.Encode(Msg{Typ: EventPoolSwapTag, Item: EventPoolSwap{PoolAddress: "0xpool"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b2, []byte(`"PoolAddress":"0xpool"`)) {
+		t.Fatalf("expected field name keys, got %s", b2)
+	}
+	msg, err := JSON.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Id == "" || msg.Time.IsZero() {
+		t.Fatalf("expected id and timestamp, got %v", msg)
+	}
+	item, ok := msg.Item.(EventTokenTransfer)
+	if !ok || item != ev {
+		t.Fatalf("expected %v, got %v", ev, msg.Item)
+	}
+
+	_, err = JSON.Decode([]byte(`{"type":"TOKEN_TRANSFER","version":2,"item":{}}`))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected unsupported version, got %v", err)
+	}
+	_, err = JSON.Decode([]byte(`{"type":"TOKEN_TRANSFER","item":{}}`))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected missing version to be rejected, got %v", err)
+	}
+	_, err = JSON.Decode([]byte(`{"type":"FOO","version":1,"item":{}}`))
+	if !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("expected unknown type, got %v", err)
+	}
+}
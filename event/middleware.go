@@ -0,0 +1,180 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Middleware wraps the handler for a tag.
+type Middleware func(tag string, next EventsHandlerFunc) EventsHandlerFunc
+
+// chain wraps fn in the middleware, the first middleware being the outermost.
+func chain(tag string, fn EventsHandlerFunc, mws ...Middleware) EventsHandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		fn = mws[i](tag, fn)
+	}
+	return fn
+}
+
+// Recover turns a panic in the handler into an error.
+func Recover() Middleware {
+	return func(tag string, next EventsHandlerFunc) EventsHandlerFunc {
+		return func(ctx context.Context, o any) (err error) {
+			defer func() {
+				r := recover()
+				if r != nil {
+					err = fmt.Errorf("handler for %s panicked: %v", tag, r)
+				}
+			}()
+			return next(ctx, o)
+		}
+	}
+}
+
+// Logging logs each handled event, and the error if handling failed.
+func Logging() Middleware {
+	return func(tag string, next EventsHandlerFunc) EventsHandlerFunc {
+		return func(ctx context.Context, o any) error {
+			logg.TraceCtxf(ctx, "handling event", "tag", tag)
+			err := next(ctx, o)
+			if err != nil {
+				logg.ErrorCtxf(ctx, "event handler failed", "tag", tag, "err", err)
+				return err
+			}
+			logg.DebugCtxf(ctx, "event handled", "tag", tag)
+			return nil
+		}
+	}
+}
+
+// Timing reports the duration and outcome of each handled event to observe.
+func Timing(observe func(tag string, d time.Duration, err error)) Middleware {
+	return func(tag string, next EventsHandlerFunc) EventsHandlerFunc {
+		return func(ctx context.Context, o any) error {
+			start := time.Now()
+			err := next(ctx, o)
+			observe(tag, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Retry calls the handler up to attempts times while it fails, doubling the
+// delay between attempts from base. It gives up early when ctx is done.
+//
+// The handler is always called at least once, whatever the value of attempts.
+func Retry(attempts int, base time.Duration) Middleware {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return func(tag string, next EventsHandlerFunc) EventsHandlerFunc {
+		return func(ctx context.Context, o any) error {
+			var err error
+			delay := base
+			for i := 0; i < attempts; i++ {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+					case <-time.After(delay):
+					}
+					delay *= 2
+				}
+				err = next(ctx, o)
+				if err == nil {
+					return nil
+				}
+				logg.DebugCtxf(ctx, "event handler attempt failed", "tag", tag, "attempt", i+1, "err", err)
+			}
+			return err
+		}
+	}
+}
+
+// Timeout cancels the handler context after d, and returns the context error
+// if the handler has not returned by then.
+func Timeout(d time.Duration) Middleware {
+	return func(tag string, next EventsHandlerFunc) EventsHandlerFunc {
+		return func(ctx context.Context, o any) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			c := make(chan error, 1)
+			go func() {
+				c <- next(ctx, o)
+			}()
+			select {
+			case err := <-c:
+				return err
+			case <-ctx.Done():
+				return fmt.Errorf("handler for %s: %w", tag, ctx.Err())
+			}
+		}
+	}
+}
+
+// txHash returns the tx hash of token transfer, mint, swap and deposit events.
+func txHash(o any) string {
+	switch v := o.(type) {
+	case EventTokenTransfer:
+		return v.TxHash
+	case *EventTokenTransfer:
+		return v.TxHash
+	case EventTokenMint:
+		return v.TxHash
+	case *EventTokenMint:
+		return v.TxHash
+	case EventPoolSwap:
+		return v.TxHash
+	case *EventPoolSwap:
+		return v.TxHash
+	case EventPoolDeposit:
+		return v.TxHash
+	case *EventPoolDeposit:
+		return v.TxHash
+	}
+	return ""
+}
+
+// Dedup skips events whose tx hash was among the last size successfully
+// handled for the same tag, or is being handled. Events without a tx hash are
+// always handled.
+func Dedup(size int) Middleware {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var order []string
+
+	return func(tag string, next EventsHandlerFunc) EventsHandlerFunc {
+		return func(ctx context.Context, o any) error {
+			hsh := txHash(o)
+			if hsh == "" {
+				return next(ctx, o)
+			}
+			k := tag + "/" + hsh
+			// the hash is reserved before the handler runs, so that concurrent
+			// deliveries of the same event are handled once
+			mu.Lock()
+			ok := seen[k]
+			seen[k] = true
+			mu.Unlock()
+			if ok {
+				logg.DebugCtxf(ctx, "skipping duplicate event", "tag", tag, "hash", hsh)
+				return nil
+			}
+			err := next(ctx, o)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				delete(seen, k)
+				return err
+			}
+			order = append(order, k)
+			if len(order) > size {
+				delete(seen, order[0])
+				order = order[1:]
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	var fails int
+	ctx := context.Background()
+	tracer := func(name string) Middleware {
+		return func(tag string, next EventsHandlerFunc) EventsHandlerFunc {
+			return func(ctx context.Context, o any) error {
+				calls = append(calls, name)
+				return next(ctx, o)
+			}
+		}
+	}
+	eh := NewEventsHandler().WithHandler(EventTokenTransferTag, func(ctx context.Context, o any) error {
+		if fails > 0 {
+			fails--
+			return errors.New("transient")
+		}
+		calls = append(calls, "handler")
+		return nil
+	}).WithHandler(EventRegistrationTag, func(ctx context.Context, o any) error {
+		panic("boom")
+	})
+	eh.WithMiddleware(Recover(), tracer("global"), Dedup(8))
+	eh.WithTagMiddleware(EventTokenTransferTag, tracer("tag"), Retry(3, time.Millisecond))
+
+	fails = 2
+	ev := EventTokenTransfer{TxHash: "0xabcd"}
+	err := eh.Handle(ctx, EventTokenTransferTag, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "global,tag,handler" {
+		t.Fatalf("unexpected call order %v", calls)
+	}
+
+	// the same tx is not handled twice
+	calls = nil
+	err = eh.Handle(ctx, EventTokenTransferTag, &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected duplicate to stop at dedup, got %v", calls)
+	}
+
+	err = eh.Handle(ctx, EventRegistrationTag, EventCustodialRegistration{})
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Fatalf("expected recovered panic, got %v", err)
+	}
+
+	// a handler is called even without retries
+	calls = nil
+	once := NewEventsHandler().WithHandler(EventTokenMintTag, func(ctx context.Context, o any) error {
+		calls = append(calls, "handler")
+		return nil
+	}).WithMiddleware(Retry(0, time.Millisecond))
+	err = once.Handle(ctx, EventTokenMintTag, EventTokenMint{})
+	if err != nil || len(calls) != 1 {
+		t.Fatalf("expected one call, got %v with %v", calls, err)
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	slow := NewEventsHandler().WithHandler(EventTokenMintTag, func(ctx context.Context, o any) error {
+		<-block
+		return nil
+	}).WithMiddleware(Timeout(time.Millisecond))
+	err = slow.Handle(ctx, EventTokenMintTag, EventTokenMint{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestDedupConcurrent(t *testing.T) {
+	var handled atomic.Int32
+	release := make(chan struct{})
+	fail := true
+	eh := NewEventsHandler().WithHandler(EventTokenTransferTag, func(ctx context.Context, o any) error {
+		handled.Add(1)
+		<-release
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}).WithMiddleware(Dedup(8))
+
+	ctx := context.Background()
+	ev := EventTokenTransfer{TxHash: "0xabcd"}
+	c := make(chan error)
+	go func() {
+		c <- eh.Handle(ctx, EventTokenTransferTag, ev)
+	}()
+	for handled.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// a delivery while the first is being handled is skipped
+	err := eh.Handle(ctx, EventTokenTransferTag, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	err = <-c
+	if err == nil {
+		t.Fatal("expected handler error")
+	}
+	if handled.Load() != 1 {
+		t.Fatalf("expected one call, got %d", handled.Load())
+	}
+
+	// a failed event is handled again
+	fail = false
+	err = eh.Handle(ctx, EventTokenTransferTag, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled.Load() != 2 {
+		t.Fatalf("expected failed event to be handled again, got %d calls", handled.Load())
+	}
+}
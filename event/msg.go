@@ -3,6 +3,7 @@ package event
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 const (
@@ -10,11 +11,18 @@ const (
 	EventTokenTransferTag = "TOKEN_TRANSFER"
 	EventTokenMintTag     = "TOKEN_MINT"
 	EventRegistrationTag  = "CUSTODIAL_REGISTRATION"
+	EventPoolSwapTag      = "POOL_SWAP"
+	EventPoolDepositTag   = "POOL_DEPOSIT"
+	EventAliasTag         = "ALIAS_REGISTRATION"
 )
 
 type Msg struct {
 	Typ  string
 	Item any
+	// Id identifies the event across deliveries. It is set by Codec.Encode if empty.
+	Id string
+	// Time is when the event occurred. It is set by Codec.Encode if zero.
+	Time time.Time
 }
 
 type EmitterFunc func(context.Context, Msg) error
@@ -40,15 +48,44 @@ type EventTokenMint struct {
 	VoucherAddress string
 }
 
+// fields used for handling pool swap event.
+type EventPoolSwap struct {
+	From               string
+	PoolAddress        string
+	FromVoucherAddress string
+	ToVoucherAddress   string
+	InValue            int
+	OutValue           int
+	TxHash             string
+}
+
+// fields used for handling pool deposit event.
+type EventPoolDeposit struct {
+	From           string
+	PoolAddress    string
+	VoucherAddress string
+	Value          int
+	TxHash         string
+}
+
+// fields used for handling alias registration event.
+type EventAlias struct {
+	Account string
+	Alias   string
+}
+
 type EventsHandlerFunc func(context.Context, any) error
 
 type EventsHandler struct {
-	handlers map[string]EventsHandlerFunc
+	handlers      map[string]EventsHandlerFunc
+	middleware    []Middleware
+	tagMiddleware map[string][]Middleware
 }
 
 func NewEventsHandler() *EventsHandler {
 	return &EventsHandler{
-		handlers: make(map[string]EventsHandlerFunc),
+		handlers:      make(map[string]EventsHandlerFunc),
+		tagMiddleware: make(map[string][]Middleware),
 	}
 }
 
@@ -57,10 +94,25 @@ func (eh *EventsHandler) WithHandler(tag string, fn EventsHandlerFunc) *EventsHa
 	return eh
 }
 
+// WithMiddleware adds middleware wrapping the handlers of all tags. It runs
+// outside of any middleware added with WithTagMiddleware.
+func (eh *EventsHandler) WithMiddleware(mws ...Middleware) *EventsHandler {
+	eh.middleware = append(eh.middleware, mws...)
+	return eh
+}
+
+// WithTagMiddleware adds middleware wrapping the handler of a single tag.
+func (eh *EventsHandler) WithTagMiddleware(tag string, mws ...Middleware) *EventsHandler {
+	eh.tagMiddleware[tag] = append(eh.tagMiddleware[tag], mws...)
+	return eh
+}
+
 func (eh *EventsHandler) Handle(ctx context.Context, tag string, o any) error {
 	fn, ok := eh.handlers[tag]
 	if !ok {
 		return fmt.Errorf("Handler not registered for tag: %s", tag)
 	}
+	fn = chain(tag, fn, eh.tagMiddleware[tag]...)
+	fn = chain(tag, fn, eh.middleware...)
 	return fn(ctx, o)
 }
@@ -12,6 +12,7 @@ require (
 	github.com/grassrootseconomics/eth-custodial v1.12.0-rc
 	github.com/grassrootseconomics/ussd-data-service v1.10.1-beta
 	github.com/stretchr/testify v1.11.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -34,5 +35,4 @@ require (
 	golang.org/x/sync v0.18.0 // indirect
 	golang.org/x/text v0.31.0 // indirect
 	gopkg.in/leonelquinteros/gotext.v1 v1.3.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
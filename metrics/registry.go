@@ -0,0 +1,342 @@
+// Package metrics provides counters and histograms that can be rendered in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default upper bounds of histogram buckets, in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry used when no other is given.
+var Default = NewRegistry()
+
+type metric interface {
+	typ() string
+	write(w io.Writer, name string) error
+}
+
+type family struct {
+	name   string
+	help   string
+	labels []string
+	metric metric
+}
+
+// Registry holds named metrics.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// register returns the metric registered with name, or registers the one made by mk.
+//
+// It panics if name is registered with a different type or labels, as the
+// metrics are defined by code and not by input.
+func (r *Registry) register(name string, help string, labels []string, typ string, mk func() metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if ok {
+		if f.metric.typ() != typ || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metric %s already registered as %s with labels %v", name, f.metric.typ(), f.labels))
+		}
+		return f.metric
+	}
+	f = &family{
+		name:   name,
+		help:   help,
+		labels: labels,
+		metric: mk(),
+	}
+	r.families[name] = f
+	return f.metric
+}
+
+// Counter returns the counter registered with name, registering it if needed.
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	return r.register(name, help, labels, "counter", func() metric {
+		return &Counter{
+			labels: labels,
+			series: make(map[string]*counterSeries),
+		}
+	}).(*Counter)
+}
+
+// Histogram returns the histogram registered with name, registering it if
+// needed. If buckets is nil, DefaultBuckets are used.
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return r.register(name, help, labels, "histogram", func() metric {
+		b := append([]float64{}, buckets...)
+		sort.Float64s(b)
+		return &Histogram{
+			labels:  labels,
+			buckets: b,
+			series:  make(map[string]*histogramSeries),
+		}
+	}).(*Histogram)
+}
+
+// WriteText writes all metrics to w in the Prometheus text exposition format,
+// sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	var families []*family
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	for _, f := range families {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.metric.typ())
+		if err != nil {
+			return err
+		}
+		err = f.metric.write(w, f.name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+// Counter is a monotonically increasing value per set of label values.
+type Counter struct {
+	labels []string
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// Inc adds 1 to the series with the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the series with the given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	k := seriesKey(c.labels, values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[k]
+	if !ok {
+		s = &counterSeries{
+			values: values,
+		}
+		c.series[k] = s
+	}
+	s.value += v
+}
+
+// Value returns the value of the series with the given label values.
+func (c *Counter) Value(values ...string) float64 {
+	k := seriesKey(c.labels, values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[k]
+	if !ok {
+		return 0
+	}
+	return s.value
+}
+
+func (c *Counter) typ() string {
+	return "counter"
+}
+
+func (c *Counter) write(w io.Writer, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.series) {
+		s := c.series[k]
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(c.labels, s.values), formatValue(s.value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram counts observations in buckets per set of label values.
+type Histogram struct {
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	// counts holds the observations per bucket, not cumulative, with the last
+	// for those above all buckets.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe records v in the series with the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	k := seriesKey(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{
+			values: values,
+			counts: make([]uint64, len(h.buckets)+1),
+		}
+		h.series[k] = s
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// Count returns the number of observations of the series with the given label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	k := seriesKey(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		return 0
+	}
+	return s.count
+}
+
+func (h *Histogram) typ() string {
+	return "histogram"
+}
+
+func (h *Histogram) write(w io.Writer, name string) error {
+	labels := append(append([]string{}, h.labels...), "le")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		var n uint64
+		for i, c := range s.counts {
+			n += c
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			values := append(append([]string{}, s.values...), formatValue(le))
+			_, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, values), n)
+			if err != nil {
+				return err
+			}
+		}
+		lbl := formatLabels(h.labels, s.values)
+		_, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, lbl, formatValue(s.sum), name, lbl, s.count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesKey identifies the series of the label values. Missing values are
+// empty, and extra values are ignored.
+func seriesKey(labels []string, values []string) string {
+	var b strings.Builder
+	for i := range labels {
+		if i < len(values) {
+			b.WriteString(values[i])
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(labels []string, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(v))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryText(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("calls_total", "Calls.", "method")
+	c.Inc("foo")
+	c.Add(2, "foo")
+	c.Inc(`b"ar`)
+	if reg.Counter("calls_total", "Calls.", "method") != c {
+		t.Fatal("expected counter to be registered once")
+	}
+	h := reg.Histogram("duration_seconds", "Duration.", []float64{0.1, 1}, "method")
+	h.Observe(0.05, "foo")
+	h.Observe(0.1, "foo")
+	h.Observe(3, "foo")
+
+	var b bytes.Buffer
+	err := reg.WriteText(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `# HELP calls_total Calls.
+# TYPE calls_total counter
+calls_total{method="b\"ar"} 1
+calls_total{method="foo"} 3
+# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{method="foo",le="0.1"} 2
+duration_seconds_bucket{method="foo",le="1"} 2
+duration_seconds_bucket{method="foo",le="+Inf"} 3
+duration_seconds_sum{method="foo"} 3.15
+duration_seconds_count{method="foo"} 3
+`
+	if b.String() != expect {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on registering a name with another type")
+		}
+	}()
+	reg.Histogram("calls_total", "Calls.", nil, "method")
+}
@@ -16,3 +16,17 @@ type Transaction struct {
 type TrackStatusResult struct {
 	Active bool `json:"active"`
 }
+
+// Statuses of a transaction as reported by TrackTransaction.
+const (
+	TxStatusPending   = "pending"
+	TxStatusSubmitted = "submitted"
+	TxStatusConfirmed = "confirmed"
+	TxStatusFailed    = "failed"
+)
+
+type TrackTransactionResult struct {
+	Status        string `json:"status"`
+	TxHash        string `json:"txHash"`
+	FailureReason string `json:"failureReason"`
+}
@@ -7,10 +7,63 @@ import (
 	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
 )
 
+// Names of the AccountService operations, used to key per-operation settings
+// such as timeouts.
+const (
+	MethodCheckBalance                 = "CheckBalance"
+	MethodCreateAccount                = "CreateAccount"
+	MethodTrackAccountStatus           = "TrackAccountStatus"
+	MethodTrackTransaction             = "TrackTransaction"
+	MethodFetchVouchers                = "FetchVouchers"
+	MethodFetchTransactions            = "FetchTransactions"
+	MethodVoucherData                  = "VoucherData"
+	MethodTokenTransfer                = "TokenTransfer"
+	MethodCheckAliasAddress            = "CheckAliasAddress"
+	MethodRequestAlias                 = "RequestAlias"
+	MethodUpdateAlias                  = "UpdateAlias"
+	MethodSendUpsellSMS                = "SendUpsellSMS"
+	MethodSendAddressSMS               = "SendAddressSMS"
+	MethodSendPINResetSMS              = "SendPINResetSMS"
+	MethodPoolDeposit                  = "PoolDeposit"
+	MethodFetchTopPools                = "FetchTopPools"
+	MethodRetrievePoolDetails          = "RetrievePoolDetails"
+	MethodGetPoolSwappableFromVouchers = "GetPoolSwappableFromVouchers"
+	MethodGetPoolSwappableVouchers     = "GetPoolSwappableVouchers"
+	MethodGetPoolSwapQuote             = "GetPoolSwapQuote"
+	MethodPoolSwap                     = "PoolSwap"
+	MethodGetSwapFromTokenMaxLimit     = "GetSwapFromTokenMaxLimit"
+	MethodCheckTokenInPool             = "CheckTokenInPool"
+	MethodGetCreditSendMaxLimit        = "GetCreditSendMaxLimit"
+	MethodGetCreditSendReverseQuote    = "GetCreditSendReverseQuote"
+	MethodMpesaTriggerOnramp           = "MpesaTriggerOnramp"
+	MethodGetMpesaOnrampRates          = "GetMpesaOnrampRates"
+)
+
+// writeMethods holds the operations that change state upstream.
+var writeMethods = map[string]bool{
+	MethodCreateAccount:      true,
+	MethodTokenTransfer:      true,
+	MethodRequestAlias:       true,
+	MethodUpdateAlias:        true,
+	MethodSendUpsellSMS:      true,
+	MethodSendAddressSMS:     true,
+	MethodSendPINResetSMS:    true,
+	MethodPoolDeposit:        true,
+	MethodPoolSwap:           true,
+	MethodMpesaTriggerOnramp: true,
+}
+
+// IsWrite reports whether the operation changes state upstream, so that
+// calling it again may repeat its effect.
+func IsWrite(method string) bool {
+	return writeMethods[method]
+}
+
 type AccountService interface {
 	CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error)
 	CreateAccount(ctx context.Context) (*models.AccountResult, error)
 	TrackAccountStatus(ctx context.Context, publicKey string) (*models.TrackStatusResult, error)
+	TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error)
 	FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error)
 	FetchTransactions(ctx context.Context, publicKey string) ([]dataserviceapi.Last10TxResponse, error)
 	VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error)
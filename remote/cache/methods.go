@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+)
+
+func (c *AccountService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	return get(ctx, c, remote.MethodCheckBalance, []string{publicKey}, func() (*models.BalanceResult, error) {
+		return c.svc.CheckBalance(ctx, publicKey)
+	})
+}
+
+func (c *AccountService) CreateAccount(ctx context.Context) (*models.AccountResult, error) {
+	return c.svc.CreateAccount(ctx)
+}
+
+func (c *AccountService) TrackAccountStatus(ctx context.Context, publicKey string) (*models.TrackStatusResult, error) {
+	return get(ctx, c, remote.MethodTrackAccountStatus, []string{publicKey}, func() (*models.TrackStatusResult, error) {
+		return c.svc.TrackAccountStatus(ctx, publicKey)
+	})
+}
+
+func (c *AccountService) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	return get(ctx, c, remote.MethodTrackTransaction, []string{trackingId}, func() (*models.TrackTransactionResult, error) {
+		return c.svc.TrackTransaction(ctx, trackingId)
+	})
+}
+
+func (c *AccountService) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
+	return get(ctx, c, remote.MethodFetchVouchers, []string{publicKey}, func() ([]dataserviceapi.TokenHoldings, error) {
+		return c.svc.FetchVouchers(ctx, publicKey)
+	})
+}
+
+func (c *AccountService) FetchTransactions(ctx context.Context, publicKey string) ([]dataserviceapi.Last10TxResponse, error) {
+	return get(ctx, c, remote.MethodFetchTransactions, []string{publicKey}, func() ([]dataserviceapi.Last10TxResponse, error) {
+		return c.svc.FetchTransactions(ctx, publicKey)
+	})
+}
+
+func (c *AccountService) VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error) {
+	return get(ctx, c, remote.MethodVoucherData, []string{address}, func() (*models.VoucherDataResult, error) {
+		return c.svc.VoucherData(ctx, address)
+	})
+}
+
+func (c *AccountService) TokenTransfer(ctx context.Context, amount, from, to, tokenAddress string) (*models.TokenTransferResponse, error) {
+	r, err := c.svc.TokenTransfer(ctx, amount, from, to, tokenAddress)
+	if err == nil {
+		c.InvalidateAddress(from, to)
+	}
+	return r, err
+}
+
+func (c *AccountService) CheckAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
+	return get(ctx, c, remote.MethodCheckAliasAddress, []string{alias}, func() (*models.AliasAddress, error) {
+		return c.svc.CheckAliasAddress(ctx, alias)
+	})
+}
+
+func (c *AccountService) RequestAlias(ctx context.Context, hint string, publicKey string) (*models.RequestAliasResult, error) {
+	r, err := c.svc.RequestAlias(ctx, hint, publicKey)
+	if err == nil {
+		c.InvalidateAlias(publicKey, hint, aliasOf(r))
+	}
+	return r, err
+}
+
+func (c *AccountService) UpdateAlias(ctx context.Context, name string, publicKey string) (*models.RequestAliasResult, error) {
+	r, err := c.svc.UpdateAlias(ctx, name, publicKey)
+	if err == nil {
+		c.InvalidateAlias(publicKey, name, aliasOf(r))
+	}
+	return r, err
+}
+
+func (c *AccountService) SendUpsellSMS(ctx context.Context, inviterPhone, inviteePhone string) (*models.SendSMSResponse, error) {
+	return c.svc.SendUpsellSMS(ctx, inviterPhone, inviteePhone)
+}
+
+func (c *AccountService) SendAddressSMS(ctx context.Context, publicKey, originPhone string) error {
+	return c.svc.SendAddressSMS(ctx, publicKey, originPhone)
+}
+
+func (c *AccountService) SendPINResetSMS(ctx context.Context, admin, phone string) error {
+	return c.svc.SendPINResetSMS(ctx, admin, phone)
+}
+
+func (c *AccountService) PoolDeposit(ctx context.Context, amount, from, poolAddress, tokenAddress string) (*models.PoolDepositResult, error) {
+	r, err := c.svc.PoolDeposit(ctx, amount, from, poolAddress, tokenAddress)
+	if err == nil {
+		c.InvalidateAddress(from, poolAddress)
+	}
+	return r, err
+}
+
+func (c *AccountService) FetchTopPools(ctx context.Context) ([]dataserviceapi.PoolDetails, error) {
+	return get(ctx, c, remote.MethodFetchTopPools, []string{}, func() ([]dataserviceapi.PoolDetails, error) {
+		return c.svc.FetchTopPools(ctx)
+	})
+}
+
+func (c *AccountService) RetrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolDetails, error) {
+	return get(ctx, c, remote.MethodRetrievePoolDetails, []string{sym}, func() (*dataserviceapi.PoolDetails, error) {
+		return c.svc.RetrievePoolDetails(ctx, sym)
+	})
+}
+
+func (c *AccountService) GetPoolSwappableFromVouchers(ctx context.Context, poolAddress, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
+	return get(ctx, c, remote.MethodGetPoolSwappableFromVouchers, []string{poolAddress, publicKey}, func() ([]dataserviceapi.TokenHoldings, error) {
+		return c.svc.GetPoolSwappableFromVouchers(ctx, poolAddress, publicKey)
+	})
+}
+
+func (c *AccountService) GetPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
+	return get(ctx, c, remote.MethodGetPoolSwappableVouchers, []string{poolAddress}, func() ([]dataserviceapi.TokenHoldings, error) {
+		return c.svc.GetPoolSwappableVouchers(ctx, poolAddress)
+	})
+}
+
+func (c *AccountService) GetPoolSwapQuote(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapQuoteResult, error) {
+	return get(ctx, c, remote.MethodGetPoolSwapQuote, []string{amount, from, fromTokenAddress, poolAddress, toTokenAddress}, func() (*models.PoolSwapQuoteResult, error) {
+		return c.svc.GetPoolSwapQuote(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	})
+}
+
+func (c *AccountService) PoolSwap(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapResult, error) {
+	r, err := c.svc.PoolSwap(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	if err == nil {
+		c.InvalidateAddress(from, poolAddress)
+	}
+	return r, err
+}
+
+func (c *AccountService) GetSwapFromTokenMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.MaxLimitResult, error) {
+	return get(ctx, c, remote.MethodGetSwapFromTokenMaxLimit, []string{poolAddress, fromTokenAddress, toTokenAddress, publicKey}, func() (*models.MaxLimitResult, error) {
+		return c.svc.GetSwapFromTokenMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	})
+}
+
+func (c *AccountService) CheckTokenInPool(ctx context.Context, poolAddress, tokenAddress string) (*models.TokenInPoolResult, error) {
+	return get(ctx, c, remote.MethodCheckTokenInPool, []string{poolAddress, tokenAddress}, func() (*models.TokenInPoolResult, error) {
+		return c.svc.CheckTokenInPool(ctx, poolAddress, tokenAddress)
+	})
+}
+
+func (c *AccountService) GetCreditSendMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.CreditSendLimitsResult, error) {
+	return get(ctx, c, remote.MethodGetCreditSendMaxLimit, []string{poolAddress, fromTokenAddress, toTokenAddress, publicKey}, func() (*models.CreditSendLimitsResult, error) {
+		return c.svc.GetCreditSendMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	})
+}
+
+func (c *AccountService) GetCreditSendReverseQuote(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount string) (*models.CreditSendReverseQouteResult, error) {
+	return get(ctx, c, remote.MethodGetCreditSendReverseQuote, []string{poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount}, func() (*models.CreditSendReverseQouteResult, error) {
+		return c.svc.GetCreditSendReverseQuote(ctx, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount)
+	})
+}
+
+func (c *AccountService) MpesaTriggerOnramp(ctx context.Context, address, phoneNumber, asset string, amount int) (*models.MpesaOnrampResponse, error) {
+	r, err := c.svc.MpesaTriggerOnramp(ctx, address, phoneNumber, asset, amount)
+	if err == nil {
+		c.InvalidateAddress(address)
+	}
+	return r, err
+}
+
+func (c *AccountService) GetMpesaOnrampRates(ctx context.Context) (*models.MpesaOnrampRatesResponse, error) {
+	return get(ctx, c, remote.MethodGetMpesaOnrampRates, []string{}, func() (*models.MpesaOnrampRatesResponse, error) {
+		return c.svc.GetMpesaOnrampRates(ctx)
+	})
+}
+
+// aliasOf returns the alias of the result of an alias request, if any.
+func aliasOf(r *models.RequestAliasResult) string {
+	if r == nil {
+		return ""
+	}
+	return r.Alias
+}
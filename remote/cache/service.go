@@ -0,0 +1,354 @@
+// Package cache provides an AccountService serving repeated reads of another
+// AccountService from memory.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("sarafu-api.cache")
+)
+
+const (
+	// DefaultSize is the default maximum number of cached results.
+	DefaultSize = 1024
+	// MaxVolatileTTL is the longest time results of the operations in Volatile are cached for.
+	MaxVolatileTTL = 5 * time.Second
+)
+
+// DefaultTTLs holds the time results are cached for by operation, for
+// operations without a TTL set with WithTTL. Operations not listed are not cached.
+var DefaultTTLs = map[string]time.Duration{
+	remote.MethodVoucherData:              time.Hour,
+	remote.MethodFetchTopPools:            5 * time.Minute,
+	remote.MethodRetrievePoolDetails:      5 * time.Minute,
+	remote.MethodGetPoolSwappableVouchers: 5 * time.Minute,
+}
+
+// Volatile holds the operations whose results depend on balances or on the
+// progress of a transaction. Their TTL is capped at MaxVolatileTTL.
+var Volatile = map[string]bool{
+	remote.MethodCheckBalance:                 true,
+	remote.MethodTrackAccountStatus:           true,
+	remote.MethodTrackTransaction:             true,
+	remote.MethodFetchVouchers:                true,
+	remote.MethodFetchTransactions:            true,
+	remote.MethodGetPoolSwappableFromVouchers: true,
+	remote.MethodGetSwapFromTokenMaxLimit:     true,
+	remote.MethodGetCreditSendMaxLimit:        true,
+}
+
+type entry struct {
+	key     string
+	method  string
+	args    []string
+	val     any
+	expires time.Time
+}
+
+// flight is a call to the wrapped service whose result is awaited by other
+// callers with the same key.
+type flight struct {
+	done chan struct{}
+	val  any
+	err  error
+	// canceled is set if the call failed after the context of its caller ended.
+	canceled bool
+}
+
+// AccountService is a remote.AccountService caching the results of reads of
+// another AccountService.
+//
+// Concurrent calls of an operation with the same arguments, that is not
+// cached yet, are served by a single call to the wrapped service. Writes are
+// always passed on, and drop the cached results involving their addresses.
+//
+// Cached results are shared between callers, and must not be modified.
+type AccountService struct {
+	svc  remote.AccountService
+	ttls map[string]time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	flights map[string]*flight
+	// gen is increased on every invalidation, so that results of calls
+	// started before it are not cached.
+	gen uint64
+}
+
+// NewAccountService creates an AccountService caching the results of svc for
+// the operations in DefaultTTLs.
+func NewAccountService(svc remote.AccountService) *AccountService {
+	c := &AccountService{
+		svc:     svc,
+		ttls:    make(map[string]time.Duration),
+		size:    DefaultSize,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		flights: make(map[string]*flight),
+	}
+	for k, v := range DefaultTTLs {
+		c.ttls[k] = v
+	}
+	return c
+}
+
+// WithTTL sets the time results of a single operation, named by one of the
+// remote.Method* constants, are cached for. A TTL of 0 disables caching of the
+// operation. Writes are never cached.
+func (c *AccountService) WithTTL(method string, ttl time.Duration) *AccountService {
+	if Volatile[method] && ttl > MaxVolatileTTL {
+		logg.Warnf("capping ttl of volatile operation", "method", method, "ttl", ttl, "max", MaxVolatileTTL)
+		ttl = MaxVolatileTTL
+	}
+	c.ttls[method] = ttl
+	return c
+}
+
+// WithSize sets the maximum number of cached results. When it is reached, the
+// least recently used result is dropped.
+func (c *AccountService) WithSize(size int) *AccountService {
+	c.size = size
+	return c
+}
+
+// Len returns the number of cached results, including expired ones not yet dropped.
+func (c *AccountService) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Invalidate drops the cached results of the operation, or of all operations
+// if method is empty.
+func (c *AccountService) Invalidate(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if method == "" || el.Value.(*entry).method == method {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// InvalidateAddress drops the cached results of all operations called with
+// one of the given addresses as an argument.
+func (c *AccountService) InvalidateAddress(addrs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if hasArg(el.Value.(*entry).args, addrs) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// InvalidateAlias drops the cached results involving the account, as well as
+// the cached alias lookups of the given aliases and of those resolving to the
+// account, for use when the alias of the account changes.
+func (c *AccountService) InvalidateAlias(account string, aliases ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*entry)
+		if hasArg(e.args, []string{account}) {
+			c.remove(el)
+		} else if e.method == remote.MethodCheckAliasAddress && (hasArg(e.args, aliases) || resolvesTo(e.val, account)) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// resolvesTo reports whether val is an alias lookup result of the account.
+func resolvesTo(val any, account string) bool {
+	r, ok := val.(*models.AliasAddress)
+	if !ok || r == nil || account == "" {
+		return false
+	}
+	return strings.EqualFold(r.Address, account)
+}
+
+// HandleEvent drops the cached results involving the addresses of token
+// transfer, mint, pool swap, pool deposit and alias events, and the lookups of
+// the old and new alias of alias events. It can be subscribed to an event.Bus
+// as an event.EmitterFunc.
+func (c *AccountService) HandleEvent(ctx context.Context, msg event.Msg) error {
+	var addrs []string
+
+	switch v := msg.Item.(type) {
+	case event.EventTokenTransfer:
+		addrs = []string{v.From, v.To}
+	case *event.EventTokenTransfer:
+		addrs = []string{v.From, v.To}
+	case event.EventTokenMint:
+		addrs = []string{v.To}
+	case *event.EventTokenMint:
+		addrs = []string{v.To}
+	case event.EventPoolSwap:
+		addrs = []string{v.From, v.PoolAddress}
+	case *event.EventPoolSwap:
+		addrs = []string{v.From, v.PoolAddress}
+	case event.EventPoolDeposit:
+		addrs = []string{v.From, v.PoolAddress}
+	case *event.EventPoolDeposit:
+		addrs = []string{v.From, v.PoolAddress}
+	case event.EventAlias:
+		logg.TraceCtxf(ctx, "invalidating on event", "type", msg.Typ, "addresses", v.Account)
+		c.InvalidateAlias(v.Account, v.Alias)
+		return nil
+	case *event.EventAlias:
+		logg.TraceCtxf(ctx, "invalidating on event", "type", msg.Typ, "addresses", v.Account)
+		c.InvalidateAlias(v.Account, v.Alias)
+		return nil
+	default:
+		return nil
+	}
+	logg.TraceCtxf(ctx, "invalidating on event", "type", msg.Typ, "addresses", addrs)
+	c.InvalidateAddress(addrs...)
+	return nil
+}
+
+func hasArg(args []string, addrs []string) bool {
+	for _, arg := range args {
+		for _, addr := range addrs {
+			if addr != "" && strings.EqualFold(arg, addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove drops a cached result. The lock must be held.
+func (c *AccountService) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// store caches a result, unless an invalidation happened since gen. The lock must be held.
+func (c *AccountService) store(gen uint64, e *entry) {
+	if gen != c.gen || c.size <= 0 {
+		return
+	}
+	el, ok := c.entries[e.key]
+	if ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func cacheKey(method string, args []string) string {
+	return method + "\x00" + strings.Join(args, "\x00")
+}
+
+// get returns the cached result of the operation with the given arguments, or
+// gets it with fn and caches it.
+//
+// If a call with the same arguments is already running, its result is
+// returned instead. If that call failed because the context of its caller
+// ended, it is made again.
+func get[T any](ctx context.Context, c *AccountService, method string, args []string, fn func() (T, error)) (T, error) {
+	var zero T
+
+	ttl := c.ttls[method]
+	if ttl <= 0 || remote.IsWrite(method) {
+		return fn()
+	}
+	key := cacheKey(method, args)
+
+	for {
+		c.mu.Lock()
+		el, ok := c.entries[key]
+		if ok {
+			e := el.Value.(*entry)
+			if c.now().Before(e.expires) {
+				c.lru.MoveToFront(el)
+				c.mu.Unlock()
+				logg.TraceCtxf(ctx, "cache hit", "method", method)
+				return e.val.(T), nil
+			}
+			c.remove(el)
+		}
+		f, ok := c.flights[key]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		logg.TraceCtxf(ctx, "awaiting running call", "method", method)
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+		if f.canceled {
+			logg.TraceCtxf(ctx, "running call canceled by its caller, calling again", "method", method)
+			continue
+		}
+		if f.err != nil {
+			return zero, f.err
+		}
+		return f.val.(T), nil
+	}
+	f := &flight{
+		done: make(chan struct{}),
+	}
+	c.flights[key] = f
+	gen := c.gen
+	c.mu.Unlock()
+
+	var r T
+	var err error
+	completed := false
+	// the flight is ended even if fn panics, so that its waiters do not
+	// wait forever
+	defer func() {
+		if !completed {
+			err = remote.NewError(remote.ErrInternal, "call of %s panicked", method)
+		}
+		c.mu.Lock()
+		delete(c.flights, key)
+		if completed && err == nil {
+			c.store(gen, &entry{
+				key:     key,
+				method:  method,
+				args:    args,
+				val:     r,
+				expires: c.now().Add(ttl),
+			})
+		}
+		c.mu.Unlock()
+		f.val = r
+		f.err = err
+		f.canceled = err != nil && ctx.Err() != nil
+		close(f.done)
+	}()
+	r, err = fn()
+	completed = true
+	return r, err
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/event"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/testutil/testservice"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+)
+
+// countingService counts the calls made to it. Calls of GetPoolSwappableVouchers
+// wait for release to be closed, if set, or for their context to end.
+type countingService struct {
+	testservice.TestAccountService
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (cs *countingService) VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error) {
+	cs.calls.Add(1)
+	return &models.VoucherDataResult{
+		TokenSymbol: "FOO",
+	}, nil
+}
+
+func (cs *countingService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	cs.calls.Add(1)
+	return cs.TestAccountService.CheckBalance(ctx, publicKey)
+}
+
+func (cs *countingService) CheckAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
+	cs.calls.Add(1)
+	return &models.AliasAddress{
+		Address: "0xdeadbeef",
+	}, nil
+}
+
+func (cs *countingService) GetPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
+	cs.calls.Add(1)
+	if cs.release != nil {
+		select {
+		case <-cs.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return []dataserviceapi.TokenHoldings{}, nil
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingService{}
+	now := time.Now()
+	c := NewAccountService(backend).WithSize(2)
+	c.now = func() time.Time {
+		return now
+	}
+
+	for i := 0; i < 3; i++ {
+		r, err := c.VoucherData(ctx, "0xfoo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.TokenSymbol != "FOO" {
+			t.Fatalf("unexpected result %v", r)
+		}
+	}
+	if backend.calls.Load() != 1 {
+		t.Fatalf("expected 1 call, got %d", backend.calls.Load())
+	}
+
+	// expiry
+	now = now.Add(time.Hour)
+	c.VoucherData(ctx, "0xfoo")
+	if backend.calls.Load() != 2 {
+		t.Fatalf("expected expired result to be fetched again, got %d calls", backend.calls.Load())
+	}
+
+	// size bound
+	c.VoucherData(ctx, "0xbar")
+	c.VoucherData(ctx, "0xbaz")
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 cached results, got %d", c.Len())
+	}
+	c.VoucherData(ctx, "0xfoo")
+	if backend.calls.Load() != 5 {
+		t.Fatalf("expected least recently used result to be dropped, got %d calls", backend.calls.Load())
+	}
+
+	// balances are not cached by default, and only briefly if asked to
+	c.CheckBalance(ctx, "0xdeadbeef")
+	c.CheckBalance(ctx, "0xdeadbeef")
+	if backend.calls.Load() != 7 {
+		t.Fatalf("expected balance not to be cached, got %d calls", backend.calls.Load())
+	}
+	c.WithTTL(remote.MethodCheckBalance, time.Hour)
+	if c.ttls[remote.MethodCheckBalance] != MaxVolatileTTL {
+		t.Fatalf("expected balance ttl to be capped, got %v", c.ttls[remote.MethodCheckBalance])
+	}
+
+	// concurrent calls are served by one call to the backend
+	backend.calls.Store(0)
+	backend.release = make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetPoolSwappableVouchers(ctx, "0xpool")
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+	if backend.calls.Load() != 1 {
+		t.Fatalf("expected concurrent calls to be deduplicated, got %d calls", backend.calls.Load())
+	}
+
+	// events drop the results involving their addresses
+	err := c.HandleEvent(ctx, event.Msg{
+		Typ: event.EventPoolSwapTag,
+		Item: event.EventPoolSwap{
+			From:        "0xdeadbeef",
+			PoolAddress: "0xPOOL",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.GetPoolSwappableVouchers(ctx, "0xpool")
+	if backend.calls.Load() != 2 {
+		t.Fatalf("expected result to be dropped on event, got %d calls", backend.calls.Load())
+	}
+
+	// alias events drop the lookups of the old and the new alias
+	backend.calls.Store(0)
+	c.WithTTL(remote.MethodCheckAliasAddress, time.Hour)
+	c.CheckAliasAddress(ctx, "old.sarafu.local")
+	c.CheckAliasAddress(ctx, "new.sarafu.local")
+	err = c.HandleEvent(ctx, event.Msg{
+		Typ: event.EventAliasTag,
+		Item: event.EventAlias{
+			Account: "0xDEADBEEF",
+			Alias:   "new.sarafu.local",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CheckAliasAddress(ctx, "old.sarafu.local")
+	c.CheckAliasAddress(ctx, "new.sarafu.local")
+	if backend.calls.Load() != 4 {
+		t.Fatalf("expected alias lookups to be dropped on event, got %d calls", backend.calls.Load())
+	}
+
+	c.Invalidate("")
+	if c.Len() != 0 {
+		t.Fatalf("expected empty cache, got %d", c.Len())
+	}
+}
+
+// panickingService panics on VoucherData, after waiting for release to be closed.
+type panickingService struct {
+	testservice.TestAccountService
+	release chan struct{}
+}
+
+func (ps *panickingService) VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error) {
+	<-ps.release
+	panic("boom")
+}
+
+func TestCacheFlight(t *testing.T) {
+	backend := &countingService{
+		release: make(chan struct{}),
+	}
+	c := NewAccountService(backend)
+
+	// a waiter is not failed by the caller of the running call going away
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := c.GetPoolSwappableVouchers(leaderCtx, "0xpool")
+		leader <- err
+	}()
+	for backend.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waiter := make(chan error)
+	go func() {
+		_, err := c.GetPoolSwappableVouchers(context.Background(), "0xpool")
+		waiter <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	err := <-leader
+	if err == nil {
+		t.Fatal("expected canceled leader to fail")
+	}
+	close(backend.release)
+	err = <-waiter
+	if err != nil {
+		t.Fatalf("expected waiter to call again, got %v", err)
+	}
+
+	// a panicking call does not leave its waiters blocked
+	pbackend := &panickingService{
+		release: make(chan struct{}),
+	}
+	c = NewAccountService(pbackend)
+	go func() {
+		defer func() {
+			recover()
+		}()
+		c.VoucherData(context.Background(), "0xfoo")
+	}()
+	for {
+		c.mu.Lock()
+		n := len(c.flights)
+		c.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(pbackend.release)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.VoucherData(ctx, "0xfoo")
+	if !errors.Is(err, remote.ErrInternal) {
+		t.Fatalf("expected internal error, got %v", err)
+	}
+}
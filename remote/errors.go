@@ -0,0 +1,106 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds returned by AccountService implementations. Use errors.Is to
+// test for them, and errors.As with *APIError to get the details.
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrInvalidAlias        = errors.New("invalid alias")
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrRateLimited         = errors.New("rate limited")
+	ErrValidation          = errors.New("validation failed")
+	ErrInternal            = errors.New("internal upstream error")
+	ErrBadResponse         = errors.New("malformed upstream response")
+)
+
+// APIError describes a failed AccountService operation.
+type APIError struct {
+	// Kind is one of the Err* error kinds of this package, or nil if the failure could not be classified.
+	Kind error
+	// Code is the error code reported by the upstream, if any.
+	Code        string
+	Description string
+	// Status is the HTTP status of the upstream response, or 0 if no response was received.
+	Status int
+	// Endpoint is the URL of the upstream request, if any.
+	Endpoint string
+	// Err is the underlying error, if any.
+	Err error
+	// Unsent is set if the request is known not to have reached the upstream,
+	// such as when the connection could not be established. Otherwise a failed
+	// write may still have been applied, even if no response was received.
+	Unsent bool
+}
+
+// NewError creates an APIError of the given kind with a formatted description.
+func NewError(kind error, format string, args ...any) *APIError {
+	return &APIError{
+		Kind:        kind,
+		Description: fmt.Sprintf(format, args...),
+	}
+}
+
+// Unsent reports whether err is an APIError for a request known not to have
+// reached the upstream, so that a failed write cannot have been applied.
+func Unsent(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Unsent
+}
+
+func (e *APIError) Error() string {
+	desc := e.Description
+	if desc == "" {
+		if e.Err != nil {
+			desc = e.Err.Error()
+		} else if e.Kind != nil {
+			desc = e.Kind.Error()
+		}
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("[%s] %s", e.Code, desc)
+	}
+	return desc
+}
+
+func (e *APIError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+var kinds = []error{
+	ErrNotFound,
+	ErrInsufficientBalance,
+	ErrInvalidAlias,
+	ErrUpstreamUnavailable,
+	ErrUnauthorized,
+	ErrRateLimited,
+	ErrValidation,
+	ErrInternal,
+	ErrBadResponse,
+}
+
+// KindOf returns the error kind of err, or nil if err is nil or not of one of
+// the error kinds of this package.
+func KindOf(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+// UpstreamUnavailableError is returned immediately for requests to an upstream
+// whose circuit breaker is open. It matches remote.ErrUpstreamUnavailable.
+type UpstreamUnavailableError struct {
+	// Upstream is the scheme and host of the unavailable service.
+	Upstream string
+	// RetryAt is when the breaker will let a probe request through again.
+	RetryAt time.Time
+}
+
+func (e *UpstreamUnavailableError) Error() string {
+	return fmt.Sprintf("upstream %s unavailable until %s", e.Upstream, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *UpstreamUnavailableError) Unwrap() error {
+	return remote.ErrUpstreamUnavailable
+}
+
+// BreakerPolicy configures when a circuit breaker opens and when it probes again.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before a single probe request is let through.
+	OpenTimeout time.Duration
+}
+
+// DefaultBreakerPolicy is used by the circuit breaker shared by all
+// HTTPAccountService instances that have no breaker of their own.
+var DefaultBreakerPolicy = BreakerPolicy{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+}
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type breakerState struct {
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// CircuitBreaker tracks the health of each upstream separately, keyed by scheme and host.
+//
+// A CircuitBreaker may be shared between several HTTPAccountService instances.
+type CircuitBreaker struct {
+	policy BreakerPolicy
+	mu     sync.Mutex
+	states map[string]*breakerState
+	now    func() time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker with the given policy.
+func NewCircuitBreaker(policy BreakerPolicy) *CircuitBreaker {
+	return &CircuitBreaker{
+		policy: policy,
+		states: make(map[string]*breakerState),
+		now:    time.Now,
+	}
+}
+
+var defaultBreaker = NewCircuitBreaker(DefaultBreakerPolicy)
+
+// upstreamOf returns the key identifying the upstream a request is sent to.
+func upstreamOf(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// allow reports whether a request to the upstream may be sent.
+//
+// When the open timeout has elapsed, exactly one caller is let through as a
+// probe; all others are rejected until the probe has been recorded.
+func (cb *CircuitBreaker) allow(upstream string) error {
+	if cb.policy.FailureThreshold <= 0 {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	st, ok := cb.states[upstream]
+	if !ok {
+		return nil
+	}
+	switch st.state {
+	case breakerOpen:
+		retryAt := st.openedAt.Add(cb.policy.OpenTimeout)
+		if cb.now().Before(retryAt) {
+			return &UpstreamUnavailableError{
+				Upstream: upstream,
+				RetryAt:  retryAt,
+			}
+		}
+		st.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return &UpstreamUnavailableError{
+			Upstream: upstream,
+			RetryAt:  st.openedAt.Add(cb.policy.OpenTimeout),
+		}
+	}
+	return nil
+}
+
+// record updates the breaker for the upstream with the outcome of a request.
+//
+// Cancellation by the caller says nothing about the upstream and is ignored,
+// while timeouts, transport errors and server errors count as failures.
+func (cb *CircuitBreaker) record(ctx context.Context, upstream string, resp *http.Response, err error) {
+	if cb.policy.FailureThreshold <= 0 {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	st, ok := cb.states[upstream]
+	if errors.Is(err, context.Canceled) {
+		if ok && st.state == breakerHalfOpen {
+			// let the next caller probe instead
+			st.state = breakerOpen
+		}
+		return
+	}
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	if !ok {
+		if !failed {
+			return
+		}
+		st = &breakerState{}
+		cb.states[upstream] = st
+	}
+	if !failed {
+		if st.state != breakerClosed {
+			logg.InfoCtxf(ctx, "circuit breaker closed", "upstream", upstream)
+		}
+		delete(cb.states, upstream)
+		return
+	}
+	st.failures++
+	if st.state == breakerHalfOpen || st.failures >= cb.policy.FailureThreshold {
+		if st.state != breakerOpen {
+			logg.WarnCtxf(ctx, "circuit breaker opened", "upstream", upstream, "failures", st.failures)
+		}
+		st.state = breakerOpen
+		st.openedAt = cb.now()
+	}
+}
+
+func (as *HTTPAccountService) breaker() *CircuitBreaker {
+	if as.Breaker == nil {
+		return defaultBreaker
+	}
+	return as.Breaker
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	"github.com/grassrootseconomics/eth-custodial/pkg/api"
+)
+
+// APIError is the error returned for failed upstream requests.
+type APIError = remote.APIError
+
+// custodialErrKinds maps eth-custodial error codes to error kinds.
+var custodialErrKinds = map[string]error{
+	api.ErrCodeInternalServerError: remote.ErrInternal,
+	api.ErrCodeInvalidJSON:         remote.ErrValidation,
+	api.ErrCodeInvalidAPIKey:       remote.ErrUnauthorized,
+	api.ErrCodeValidationFailed:    remote.ErrValidation,
+	api.ErrCodeAccountNotExists:    remote.ErrNotFound,
+	api.ErrJWTAuth:                 remote.ErrUnauthorized,
+	api.ErrNoRecordFound:           remote.ErrNotFound,
+	api.ErrBannedToken:             remote.ErrValidation,
+	api.ErrSymbolAlreadyExists:     remote.ErrValidation,
+	api.ErrPretiumLeak:             remote.ErrInternal,
+}
+
+// statusErrKind maps an HTTP status to an error kind. The data indexer and the
+// alias service report errors by status only.
+//
+// A 502, 503 or 504 may come from a gateway after the upstream behind it
+// received the request, so errors of these are never marked as unsent.
+func statusErrKind(status int) error {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return remote.ErrUnauthorized
+	case status == http.StatusNotFound:
+		return remote.ErrNotFound
+	case status == http.StatusTooManyRequests:
+		return remote.ErrRateLimited
+	case status == http.StatusBadGateway, status == http.StatusServiceUnavailable, status == http.StatusGatewayTimeout:
+		return remote.ErrUpstreamUnavailable
+	case status >= http.StatusInternalServerError:
+		return remote.ErrInternal
+	case status >= http.StatusBadRequest:
+		return remote.ErrValidation
+	}
+	return nil
+}
+
+// errKind classifies an upstream error response for the given operation.
+func errKind(op string, status int, code string, description string) error {
+	kind, ok := custodialErrKinds[code]
+	if !ok {
+		kind = statusErrKind(status)
+	}
+	if kind != remote.ErrValidation {
+		return kind
+	}
+	desc := strings.ToLower(description)
+	if strings.Contains(desc, "insufficient") {
+		return remote.ErrInsufficientBalance
+	}
+	switch op {
+	case remote.MethodRequestAlias, remote.MethodUpdateAlias:
+		return remote.ErrInvalidAlias
+	}
+	return kind
+}
+
+// isDialError reports whether err happened while connecting to the upstream,
+// before any of the request was written.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// newTransportError wraps an error that prevented a response from being received.
+//
+// Unless the upstream could not be connected to, the request may have been
+// received and acted on, so the error is not marked as unsent.
+func newTransportError(req *http.Request, err error) *APIError {
+	var kind error
+	if !errors.Is(err, context.Canceled) {
+		kind = remote.ErrUpstreamUnavailable
+	}
+	return &APIError{
+		Kind:     kind,
+		Endpoint: req.URL.String(),
+		Err:      err,
+		Unsent:   isDialError(err),
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+// HealthTimeout bounds each upstream probe made by Health.
+var HealthTimeout = 5 * time.Second
+
+// probe checks that the upstream at base answers HTTP requests.
+//
+// Any response below 500 counts as healthy, since the base path of most
+// upstreams is not routed.
+func (as *HTTPAccountService) probe(ctx context.Context, base string) error {
+	ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", base, nil)
+	if err != nil {
+		return err
+	}
+	resp, _, err := as.roundTrip(req)
+	if err != nil {
+		return newTransportError(req, err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &APIError{
+			Kind:        statusErrKind(resp.StatusCode),
+			Description: http.StatusText(resp.StatusCode),
+			Status:      resp.StatusCode,
+			Endpoint:    req.URL.String(),
+		}
+	}
+	return nil
+}
+
+// Health probes every configured upstream concurrently and returns the
+// result for each, keyed by the service names of config.Config.Bases.
+// A nil value means the upstream is reachable.
+func (as *HTTPAccountService) Health(ctx context.Context) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	r := make(map[string]error)
+	for name, base := range as.config().Bases() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := as.probe(ctx, base)
+			if err != nil {
+				logg.WarnCtxf(ctx, "upstream health check failed", "upstream", name, "err", err)
+			}
+			mu.Lock()
+			r[name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return r
+}
+
+// Ping returns an error matching remote.ErrUpstreamUnavailable if any upstream fails its health check.
+func (as *HTTPAccountService) Ping(ctx context.Context) error {
+	var errs []error
+	for name, err := range as.Health(ctx) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", remote.ErrUpstreamUnavailable, errors.Join(errs...))
+}
@@ -0,0 +1,254 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"git.defalsify.org/vise.git/logging"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+const redacted = "[redacted]"
+
+// maxLoggedBody caps the number of bytes of a body that are written to the log.
+const maxLoggedBody = 2048
+
+var (
+	phoneRegex   = regexp.MustCompile(`(?:\+|\b)\d{9,15}\b`)
+	addressRegex = regexp.MustCompile(`0x[0-9a-fA-F]{40}`)
+)
+
+// JSON field names, in lower case, holding each kind of sensitive value.
+var (
+	tokenFields = map[string]bool{
+		"authorization": true,
+		"token":         true,
+		"accesstoken":   true,
+		"bearertoken":   true,
+		"pin":           true,
+		"password":      true,
+	}
+	amountFields = map[string]bool{
+		"amount":        true,
+		"balance":       true,
+		"value":         true,
+		"transfervalue": true,
+		"outvalue":      true,
+		"max":           true,
+		"maxrat":        true,
+		"maxsat":        true,
+		"inputamount":   true,
+		"outputamount":  true,
+	}
+	addressFields = map[string]bool{
+		"address":          true,
+		"from":             true,
+		"to":               true,
+		"sender":           true,
+		"recipient":        true,
+		"publickey":        true,
+		"tokenaddress":     true,
+		"fromtokenaddress": true,
+		"totokenaddress":   true,
+	}
+)
+
+// routeParams holds the template of the trailing path segments of the
+// operations that take parameters in the request path. The URLs of these
+// operations are logged with the template in place of the parameters.
+var routeParams = map[string]string{
+	remote.MethodTrackAccountStatus:           "{publicKey}",
+	remote.MethodTrackTransaction:             "{trackingId}",
+	remote.MethodCheckBalance:                 "{publicKey}",
+	remote.MethodFetchVouchers:                "{publicKey}",
+	remote.MethodFetchTransactions:            "{publicKey}",
+	remote.MethodVoucherData:                  "{address}",
+	remote.MethodCheckAliasAddress:            "{alias}",
+	remote.MethodRetrievePoolDetails:          "{symbol}",
+	remote.MethodGetPoolSwappableFromVouchers: "{poolAddress}/from/{publicKey}",
+	remote.MethodGetPoolSwappableVouchers:     "{poolAddress}/to",
+	remote.MethodGetSwapFromTokenMaxLimit:     "{poolAddress}/limit/{fromTokenAddress}/{toTokenAddress}/{publicKey}",
+	remote.MethodCheckTokenInPool:             "{poolAddress}/check/{tokenAddress}",
+	remote.MethodGetCreditSendMaxLimit:        "{poolAddress}/{fromTokenAddress}/{toTokenAddress}/{publicKey}",
+	remote.MethodGetCreditSendReverseQuote:    "{poolAddress}/{fromTokenAddress}/{toTokenAddress}/{amount}",
+}
+
+// LogPolicy controls how upstream requests and responses are logged.
+//
+// Request headers are never logged. Bodies and URLs are redacted according to
+// the Redact* flags before they are written.
+type LogPolicy struct {
+	// Level is the log level of the request and response summary lines.
+	Level int
+	// BodyLevel is the log level of request and response bodies. Use logging.LVL_NONE to never log bodies.
+	BodyLevel int
+	// RedactPhones masks phone numbers, keeping the last three digits.
+	RedactPhones bool
+	// RedactTokens masks credentials such as tokens and PINs.
+	RedactTokens bool
+	// RedactAmounts masks amounts and balances.
+	RedactAmounts bool
+	// RedactAddresses masks account and token addresses.
+	RedactAddresses bool
+}
+
+// DefaultLogPolicy is used when HTTPAccountService.Log is nil.
+var DefaultLogPolicy = LogPolicy{
+	Level:         logging.LVL_DEBUG,
+	BodyLevel:     logging.LVL_TRACE,
+	RedactPhones:  true,
+	RedactTokens:  true,
+	RedactAmounts: true,
+}
+
+func maskPhone(s string) string {
+	if len(s) <= 3 {
+		return redacted
+	}
+	return strings.Repeat("*", len(s)-3) + s[len(s)-3:]
+}
+
+// String redacts phone numbers and addresses found anywhere in s.
+func (p LogPolicy) String(s string) string {
+	if p.RedactAddresses {
+		s = addressRegex.ReplaceAllString(s, redacted)
+	}
+	if p.RedactPhones {
+		s = phoneRegex.ReplaceAllStringFunc(s, maskPhone)
+	}
+	return s
+}
+
+// URL returns the route of a request URL of the given operation. Path
+// parameters are replaced by the template of the route, and the query is left
+// out. Paths of operations without a known route are redacted as with String.
+func (p LogPolicy) URL(op string, u *url.URL) string {
+	path := u.Path
+	tmpl, ok := routeParams[op]
+	if ok {
+		segs := strings.Split(strings.TrimSuffix(path, "/"), "/")
+		n := strings.Count(tmpl, "/") + 1
+		if len(segs) > n {
+			path = strings.Join(segs[:len(segs)-n], "/") + "/" + tmpl
+		} else {
+			path = "/" + redacted
+		}
+	}
+	return p.String(u.Scheme + "://" + u.Host + path)
+}
+
+// Error redacts the message of err. The request URL included in transport
+// errors is left out, as paths and queries may hold aliases and phone numbers.
+// It returns "" if err is nil.
+func (p LogPolicy) Error(err error) string {
+	if err == nil {
+		return ""
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return p.String(err.Error())
+}
+
+// Body redacts a request or response body. JSON bodies are redacted field by
+// field, anything else is treated as plain text.
+func (p LogPolicy) Body(b []byte) string {
+	var v any
+	if len(b) == 0 {
+		return "-"
+	}
+	s := string(b)
+	if err := json.Unmarshal(b, &v); err == nil {
+		out, err := json.Marshal(p.value("", v))
+		if err == nil {
+			s = string(out)
+		}
+	} else {
+		s = p.String(s)
+	}
+	if len(s) > maxLoggedBody {
+		s = s[:maxLoggedBody] + "..."
+	}
+	return s
+}
+
+// value redacts a decoded JSON value found under the given field name.
+func (p LogPolicy) value(field string, v any) any {
+	field = strings.ToLower(field)
+	switch vv := v.(type) {
+	case map[string]any:
+		for k, e := range vv {
+			vv[k] = p.value(k, e)
+		}
+		return vv
+	case []any:
+		for i, e := range vv {
+			vv[i] = p.value(field, e)
+		}
+		return vv
+	case nil, bool:
+		return v
+	}
+	switch {
+	case p.RedactTokens && tokenFields[field]:
+		return redacted
+	case p.RedactAmounts && amountFields[field]:
+		return redacted
+	case p.RedactAddresses && addressFields[field]:
+		return redacted
+	case p.RedactPhones && strings.Contains(field, "phone"):
+		if s, ok := v.(string); ok {
+			return maskPhone(s)
+		}
+		return redacted
+	}
+	if s, ok := v.(string); ok {
+		return p.String(s)
+	}
+	return v
+}
+
+func (as *HTTPAccountService) logPolicy() LogPolicy {
+	if as.Log == nil {
+		return DefaultLogPolicy
+	}
+	return *as.Log
+}
+
+// logAt writes to the logger at the given level.
+func logAt(ctx context.Context, level int, msg string, args ...any) {
+	switch level {
+	case logging.LVL_ERROR:
+		logg.ErrorCtxf(ctx, msg, args...)
+	case logging.LVL_WARN:
+		logg.WarnCtxf(ctx, msg, args...)
+	case logging.LVL_INFO:
+		logg.InfoCtxf(ctx, msg, args...)
+	case logging.LVL_DEBUG:
+		logg.DebugCtxf(ctx, msg, args...)
+	case logging.LVL_TRACE:
+		logg.TraceCtxf(ctx, msg, args...)
+	}
+}
+
+// logRequest logs an outgoing request with its route and the body read from it.
+func (as *HTTPAccountService) logRequest(ctx context.Context, op string, req *http.Request, body []byte) {
+	p := as.logPolicy()
+	logAt(ctx, p.Level, "outgoing request", "op", op, "method", req.Method, "url", p.URL(op, req.URL), "content-type", req.Header.Get("Content-Type"))
+	if req.Body != nil {
+		logAt(ctx, p.BodyLevel, "outgoing request body", "op", op, "body", p.Body(body))
+	}
+}
+
+// logResponse logs a response received from upstream.
+func (as *HTTPAccountService) logResponse(ctx context.Context, op string, req *http.Request, resp *http.Response, body []byte) {
+	p := as.logPolicy()
+	logAt(ctx, p.Level, "received response", "op", op, "url", p.URL(op, req.URL), "status", resp.StatusCode, "content-type", resp.Header.Get("Content-Type"))
+	logAt(ctx, p.BodyLevel, "received response body", "op", op, "body", p.Body(body))
+}
@@ -0,0 +1,35 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/metrics"
+)
+
+// ResponsesMetric is the name of the counter of upstream responses, by
+// operation, upstream and status code. Requests failing without a response
+// are counted with the status "error".
+const ResponsesMetric = "sarafu_api_http_responses_total"
+
+// WithMetrics sets the registry the upstream responses are counted in.
+func (as *HTTPAccountService) WithMetrics(reg *metrics.Registry) *HTTPAccountService {
+	as.Metrics = reg
+	return as
+}
+
+func (as *HTTPAccountService) metrics() *metrics.Registry {
+	if as.Metrics == nil {
+		return metrics.Default
+	}
+	return as.Metrics
+}
+
+// countResponse records the outcome of a request sent to the upstream.
+func (as *HTTPAccountService) countResponse(op string, upstream string, resp *http.Response, err error) {
+	status := "error"
+	if err == nil && resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	as.metrics().Counter(ResponsesMetric, "Responses of upstream requests, by status code.", "op", "upstream", "status").Inc(op, upstream, status)
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how requests that failed with a transient error are retried.
+//
+// Requests with safe methods (GET, HEAD, OPTIONS) are retried on any transient
+// error. Writes such as TokenTransfer and PoolSwap are only retried if the
+// connection to the upstream could not be established, since otherwise a
+// failure does not tell whether the upstream acted on them.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry. It doubles on each subsequent retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used when HTTPAccountService.Retry is nil.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// NoRetry disables retries altogether.
+var NoRetry = RetryPolicy{
+	MaxAttempts: 1,
+}
+
+// retryableStatus lists the status codes that indicate a transient upstream condition.
+var retryableStatus = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry (starting at 1), using
+// exponential growth with equal jitter.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// parseRetryAfter interprets a Retry-After header value given either as
+// delay seconds or as an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := time.Until(t)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+func (as *HTTPAccountService) retryPolicy() RetryPolicy {
+	if as.Retry == nil {
+		return DefaultRetryPolicy
+	}
+	return *as.Retry
+}
+
+// roundTrip sends the request once and reads the full response body.
+func (as *HTTPAccountService) roundTrip(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := as.client().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// doWithRetry sends the request, retrying transport errors and retryable
+// status codes according to the retry policy as long as ctx allows.
+//
+// The returned response body is already closed; its content is returned separately.
+func (as *HTTPAccountService) doWithRetry(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	policy := as.retryPolicy()
+	safe := isSafeMethod(req.Method)
+	attempts := 1
+	if policy.MaxAttempts > 1 {
+		attempts = policy.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, body, err := as.roundTrip(req)
+		if attempt >= attempts || ctx.Err() != nil {
+			return resp, body, err
+		}
+		if !safe && !isDialError(err) {
+			return resp, body, err
+		}
+		if err == nil && !retryableStatus[resp.StatusCode] {
+			return resp, body, err
+		}
+		if err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			return resp, body, err
+		}
+
+		delay := policy.backoff(attempt)
+		if resp != nil {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = d
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, body, err
+		}
+		p := as.logPolicy()
+		logg.DebugCtxf(ctx, "retrying request", "method", req.Method, "upstream", upstreamOf(req.URL), "attempt", attempt, "delay", delay, "err", p.Error(err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, body, err
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/dev"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	"git.grassecon.net/grassrootseconomics/visedriver/storage"
+)
+
+// Route decides whether an operation is sent to the upstream API or served by
+// the local service.
+type Route int
+
+const (
+	// RouteDefault sends the operation to the API if UseApi is set. Otherwise
+	// the operations in LocalFallback are served locally, and all others are
+	// still sent to the API.
+	RouteDefault Route = iota
+	// RouteAPI always sends the operation to the API.
+	RouteAPI
+	// RouteLocal always serves the operation from the local service.
+	RouteLocal
+)
+
+// LocalFallback holds the operations served by the local service when UseApi
+// is not set and HTTPAccountService.Routes has no entry for them.
+var LocalFallback = map[string]bool{
+	remote.MethodCheckAliasAddress:            true,
+	remote.MethodRequestAlias:                 true,
+	remote.MethodUpdateAlias:                  true,
+	remote.MethodFetchTopPools:                true,
+	remote.MethodGetPoolSwappableFromVouchers: true,
+	remote.MethodGetPoolSwappableVouchers:     true,
+	remote.MethodGetSwapFromTokenMaxLimit:     true,
+	remote.MethodCheckTokenInPool:             true,
+}
+
+var (
+	localMu sync.Mutex
+	// dev services built for instances without a Local service, by storage.
+	// They are kept for as long as the process runs, like the storage itself.
+	localServices = make(map[storage.StorageService]*dev.DevAccountService)
+)
+
+// WithRoute sets whether a single operation is sent to the API or served locally.
+func (as *HTTPAccountService) WithRoute(op string, route Route) *HTTPAccountService {
+	if as.Routes == nil {
+		as.Routes = make(map[string]Route)
+	}
+	as.Routes[op] = route
+	return as
+}
+
+// WithLocal sets the service that serves operations routed locally.
+func (as *HTTPAccountService) WithLocal(svc remote.AccountService) *HTTPAccountService {
+	as.Local = svc
+	return as
+}
+
+// routeLocal reports whether the operation is to be served by the local service.
+func (as *HTTPAccountService) routeLocal(op string) bool {
+	switch as.Routes[op] {
+	case RouteAPI:
+		return false
+	case RouteLocal:
+		return true
+	}
+	return !as.UseApi && LocalFallback[op]
+}
+
+// local returns the service serving operations routed locally.
+//
+// Unless Local is set, this is a DevAccountService on SS. It is built on first
+// use, and shared by all instances with the same SS, so that the db is only
+// loaded once and they all see the same state.
+func (as *HTTPAccountService) local(ctx context.Context) (remote.AccountService, error) {
+	if as.Local != nil {
+		return as.Local, nil
+	}
+	if as.SS == nil {
+		return nil, fmt.Errorf("The storage service cannot be nil")
+	}
+	// a storage service of a type that cannot be a map key would make the
+	// lookup panic
+	if !reflect.TypeOf(as.SS).Comparable() {
+		return nil, fmt.Errorf("storage service of type %T cannot be shared, set a local service instead", as.SS)
+	}
+	localMu.Lock()
+	defer localMu.Unlock()
+	svc, ok := localServices[as.SS]
+	if !ok {
+		logg.DebugCtxf(ctx, "building local dev service")
+		svc = dev.NewDevAccountService(ctx, as.SS)
+		localServices[as.SS] = svc
+	}
+	return svc, nil
+}
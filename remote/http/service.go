@@ -4,46 +4,112 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"git.defalsify.org/vise.git/logging"
 	"git.grassecon.net/grassrootseconomics/sarafu-api/config"
-	"git.grassecon.net/grassrootseconomics/sarafu-api/dev"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/metrics"
 	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
 	"git.grassecon.net/grassrootseconomics/visedriver/storage"
+	"github.com/gofrs/uuid"
 	"github.com/grassrootseconomics/eth-custodial/pkg/api"
 	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
 )
 
 var (
-	logg       = logging.NewVanilla().WithDomain("sarafu-api.devapi")
+	logg = logging.NewVanilla().WithDomain("sarafu-api.devapi")
 )
 
-type APIError struct {
-	Code        string
-	Description string
-}
-
 type ctxKey string
 
 const ctxKeyAuthToken ctxKey = "authToken"
 
-func (e *APIError) Error() string {
-	if e.Code != "" {
-		return fmt.Sprintf("[%s] %s", e.Code, e.Description)
-	}
-	return e.Description
-}
-
 type HTTPAccountService struct {
 	SS     storage.StorageService
 	UseApi bool
+	// Retry controls how failed idempotent requests are retried. If nil,
+	// DefaultRetryPolicy is used.
+	Retry *RetryPolicy
+	// Breaker fails requests fast while their upstream is unhealthy. If nil,
+	// a breaker shared by all instances and using DefaultBreakerPolicy is used.
+	Breaker *CircuitBreaker
+	// Log controls logging and redaction of upstream traffic. If nil, DefaultLogPolicy is used.
+	Log *LogPolicy
+	// Config holds the upstream URLs and credentials. If nil, the package-level
+	// values set by config.LoadConfig are used.
+	Config *config.Config
+	// Client is used for all upstream requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Timeouts overrides DefaultTimeouts for individual operations, keyed by
+	// the remote.Method* names. A zero duration disables the per-operation timeout.
+	Timeouts map[string]time.Duration
+	// Routes overrides whether individual operations, keyed by the
+	// remote.Method* names, are sent to the API or served locally.
+	Routes map[string]Route
+	// Local serves the operations routed locally. If nil, a DevAccountService
+	// on SS is used.
+	Local remote.AccountService
+	// Metrics holds the counters of upstream responses. If nil, metrics.Default is used.
+	Metrics *metrics.Registry
+}
+
+// WithClient sets the HTTP client used for upstream requests.
+func (as *HTTPAccountService) WithClient(client *http.Client) *HTTPAccountService {
+	as.Client = client
+	return as
+}
+
+// WithTimeout sets the request timeout for a single operation.
+func (as *HTTPAccountService) WithTimeout(op string, timeout time.Duration) *HTTPAccountService {
+	if as.Timeouts == nil {
+		as.Timeouts = make(map[string]time.Duration)
+	}
+	as.Timeouts[op] = timeout
+	return as
+}
+
+// WithRetryPolicy sets the retry policy for idempotent requests.
+func (as *HTTPAccountService) WithRetryPolicy(policy RetryPolicy) *HTTPAccountService {
+	as.Retry = &policy
+	return as
+}
+
+// WithCircuitBreaker sets the circuit breaker guarding upstream requests.
+func (as *HTTPAccountService) WithCircuitBreaker(cb *CircuitBreaker) *HTTPAccountService {
+	as.Breaker = cb
+	return as
+}
+
+// WithLogPolicy sets how upstream traffic is logged.
+func (as *HTTPAccountService) WithLogPolicy(policy LogPolicy) *HTTPAccountService {
+	as.Log = &policy
+	return as
+}
+
+// WithConfig sets the upstream URLs and credentials used by the service.
+func (as *HTTPAccountService) WithConfig(cfg *config.Config) *HTTPAccountService {
+	as.Config = cfg
+	return as
+}
+
+func (as *HTTPAccountService) config() *config.Config {
+	if as.Config == nil {
+		return config.Current()
+	}
+	return as.Config
+}
+
+func (as *HTTPAccountService) client() *http.Client {
+	if as.Client == nil {
+		return http.DefaultClient
+	}
+	return as.Client
 }
 
 // symbolReplacements holds mappings of invalid symbols → valid ones
@@ -69,9 +135,17 @@ func sanitizeSymbol(symbol string) string {
 //   - error: An error if any occurred during the HTTP request, reading the response, or unmarshalling the JSON data.
 //     If no error occurs, this will be nil
 func (as *HTTPAccountService) TrackAccountStatus(ctx context.Context, publicKey string) (*models.TrackStatusResult, error) {
+	if as.routeLocal(remote.MethodTrackAccountStatus) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.TrackAccountStatus(ctx, publicKey)
+	}
+
 	var r models.TrackStatusResult
 
-	ep, err := url.JoinPath(config.TrackURL, publicKey)
+	ep, err := url.JoinPath(as.config().TrackURL, publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -81,7 +155,44 @@ func (as *HTTPAccountService) TrackAccountStatus(ctx context.Context, publicKey
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodTrackAccountStatus, req, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// TrackTransaction retrieves the status of a transfer, swap or deposit from the custodial tracking API endpoint.
+// Parameters:
+//   - trackingId: The trackingId returned by TokenTransfer, PoolSwap or PoolDeposit.
+//
+// Returns:
+//   - *models.TrackTransactionResult: The status of the transaction, one of the models.TxStatus* values, with
+//     the tx hash once submitted and the failure reason if it failed.
+//   - error: An error if any occurred during the HTTP request, reading the response, or unmarshalling the JSON data.
+func (as *HTTPAccountService) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	if as.routeLocal(remote.MethodTrackTransaction) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.TrackTransaction(ctx, trackingId)
+	}
+
+	var r models.TrackTransactionResult
+
+	ep, err := url.JoinPath(as.config().TrackStatusURL, trackingId)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", ep, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = as.doRequest(ctx, remote.MethodTrackTransaction, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -97,9 +208,17 @@ func (as *HTTPAccountService) ToFqdn(alias string) string {
 // Parameters:
 //   - publicKey: The public key associated with the account whose balance needs to be checked.
 func (as *HTTPAccountService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	if as.routeLocal(remote.MethodCheckBalance) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.CheckBalance(ctx, publicKey)
+	}
+
 	var balanceResult models.BalanceResult
 
-	ep, err := url.JoinPath(config.BalanceURL, publicKey)
+	ep, err := url.JoinPath(as.config().BalanceURL, publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +228,7 @@ func (as *HTTPAccountService) CheckBalance(ctx context.Context, publicKey string
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &balanceResult)
+	_, err = as.doRequest(ctx, remote.MethodCheckBalance, req, &balanceResult)
 	return &balanceResult, err
 }
 
@@ -120,13 +239,21 @@ func (as *HTTPAccountService) CheckBalance(ctx context.Context, publicKey string
 //   - error: An error if any occurred during the HTTP request, reading the response, or unmarshalling the JSON data.
 //     If no error occurs, this will be nil.
 func (as *HTTPAccountService) CreateAccount(ctx context.Context) (*models.AccountResult, error) {
+	if as.routeLocal(remote.MethodCreateAccount) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.CreateAccount(ctx)
+	}
+
 	var r models.AccountResult
 	// Create a new request
-	req, err := http.NewRequest("POST", config.CreateAccountURL, nil)
+	req, err := http.NewRequest("POST", as.config().CreateAccountURL, nil)
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodCreateAccount, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -138,11 +265,19 @@ func (as *HTTPAccountService) CreateAccount(ctx context.Context) (*models.Accoun
 // Parameters:
 //   - publicKey: The public key associated with the account.
 func (as *HTTPAccountService) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
+	if as.routeLocal(remote.MethodFetchVouchers) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.FetchVouchers(ctx, publicKey)
+	}
+
 	var r struct {
 		Holdings []dataserviceapi.TokenHoldings `json:"holdings"`
 	}
 
-	ep, err := url.JoinPath(config.VoucherHoldingsURL, publicKey)
+	ep, err := url.JoinPath(as.config().VoucherHoldingsURL, publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +287,7 @@ func (as *HTTPAccountService) FetchVouchers(ctx context.Context, publicKey strin
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodFetchVouchers, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -169,11 +304,19 @@ func (as *HTTPAccountService) FetchVouchers(ctx context.Context, publicKey strin
 // Parameters:
 //   - publicKey: The public key associated with the account.
 func (as *HTTPAccountService) FetchTransactions(ctx context.Context, publicKey string) ([]dataserviceapi.Last10TxResponse, error) {
+	if as.routeLocal(remote.MethodFetchTransactions) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.FetchTransactions(ctx, publicKey)
+	}
+
 	var r struct {
 		Transfers []dataserviceapi.Last10TxResponse `json:"transfers"`
 	}
 
-	ep, err := url.JoinPath(config.VoucherTransfersURL, publicKey)
+	ep, err := url.JoinPath(as.config().VoucherTransfersURL, publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -183,7 +326,7 @@ func (as *HTTPAccountService) FetchTransactions(ctx context.Context, publicKey s
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodFetchTransactions, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -200,11 +343,19 @@ func (as *HTTPAccountService) FetchTransactions(ctx context.Context, publicKey s
 // Parameters:
 //   - address: The voucher address.
 func (as *HTTPAccountService) VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error) {
+	if as.routeLocal(remote.MethodVoucherData) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.VoucherData(ctx, address)
+	}
+
 	var r struct {
 		TokenDetails models.VoucherDataResult `json:"tokenDetails"`
 	}
 
-	ep, err := url.JoinPath(config.VoucherDataURL, address)
+	ep, err := url.JoinPath(as.config().VoucherDataURL, address)
 	if err != nil {
 		return nil, err
 	}
@@ -214,20 +365,33 @@ func (as *HTTPAccountService) VoucherData(ctx context.Context, address string) (
 		return nil, err
 	}
 
+	_, err = as.doRequest(ctx, remote.MethodVoucherData, req, &r)
+	if err != nil {
+		return nil, err
+	}
+
 	// Normalize symbols before returning
 	r.TokenDetails.TokenSymbol = sanitizeSymbol(r.TokenDetails.TokenSymbol)
 
-	_, err = doRequest(ctx, req, &r)
-	return &r.TokenDetails, err
+	return &r.TokenDetails, nil
 }
 
 // TokenTransfer creates a new token transfer in the custodial system.
+// The request carries an Idempotency-Key header, taken from ctx when set with remote.WithIdempotencyKey.
 // Returns:
 //   - *models.TokenTransferResponse: A pointer to an TokenTransferResponse struct containing the trackingId.
 //     If there is an error during the request or processing, this will be nil.
 //   - error: An error if any occurred during the HTTP request, reading the response, or unmarshalling the JSON data.
 //     If no error occurs, this will be nil.
 func (as *HTTPAccountService) TokenTransfer(ctx context.Context, amount, from, to, tokenAddress string) (*models.TokenTransferResponse, error) {
+	if as.routeLocal(remote.MethodTokenTransfer) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.TokenTransfer(ctx, amount, from, to, tokenAddress)
+	}
+
 	var r models.TokenTransferResponse
 
 	// Create request payload
@@ -244,11 +408,15 @@ func (as *HTTPAccountService) TokenTransfer(ctx context.Context, amount, from, t
 	}
 
 	// Create a new request
-	req, err := http.NewRequest("POST", config.TokenTransferURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", as.config().TokenTransferURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, err
+	}
+	err = setIdempotencyKey(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodTokenTransfer, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -260,23 +428,23 @@ func (as *HTTPAccountService) TokenTransfer(ctx context.Context, amount, from, t
 // Parameters:
 //   - alias: The alias of the user.
 func (as *HTTPAccountService) CheckAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
-	if as.SS == nil {
-		return nil, fmt.Errorf("The storage service cannot be nil")
-	}
-	logg.InfoCtxf(ctx, "resolving alias before formatting", "alias", alias)
-	svc := dev.NewDevAccountService(ctx, as.SS)
-	if as.UseApi {
-		logg.InfoCtxf(ctx, "resolving alias to address", "alias", alias)
-		return resolveAliasAddress(ctx, alias)
+	logg.InfoCtxf(ctx, "resolving alias before formatting", "alias", as.logPolicy().String(alias))
+	if !as.routeLocal(remote.MethodCheckAliasAddress) {
+		logg.InfoCtxf(ctx, "resolving alias to address", "alias", as.logPolicy().String(alias))
+		return as.resolveAliasAddress(ctx, alias)
 	} else {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.CheckAliasAddress(ctx, alias)
 	}
 }
 
-func resolveAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
+func (as *HTTPAccountService) resolveAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
 	var aliasEnsResult models.AliasEnsAddressResult
 
-	fullURL, err := url.JoinPath(config.AliasResolverURL, alias)
+	fullURL, err := url.JoinPath(as.config().AliasResolverURL, alias)
 	if err != nil {
 		return nil, err
 	}
@@ -286,7 +454,7 @@ func resolveAliasAddress(ctx context.Context, alias string) (*models.AliasAddres
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &aliasEnsResult)
+	_, err = as.doRequest(ctx, remote.MethodCheckAliasAddress, req, &aliasEnsResult)
 	if err != nil {
 		return nil, err
 	}
@@ -295,42 +463,55 @@ func resolveAliasAddress(ctx context.Context, alias string) (*models.AliasAddres
 }
 
 func (as *HTTPAccountService) FetchTopPools(ctx context.Context) ([]dataserviceapi.PoolDetails, error) {
-	svc := dev.NewDevAccountService(ctx, as.SS)
-	if as.UseApi {
-		return fetchCustodialTopPools(ctx)
+	if !as.routeLocal(remote.MethodFetchTopPools) {
+		return as.fetchCustodialTopPools(ctx)
 	} else {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.FetchTopPools(ctx)
 	}
 }
 
-func fetchCustodialTopPools(ctx context.Context) ([]dataserviceapi.PoolDetails, error) {
+func (as *HTTPAccountService) fetchCustodialTopPools(ctx context.Context) ([]dataserviceapi.PoolDetails, error) {
 	var r struct {
 		TopPools []dataserviceapi.PoolDetails `json:"topPools"`
 	}
 
-	req, err := http.NewRequest("GET", config.TopPoolsURL, nil)
+	req, err := http.NewRequest("GET", as.config().TopPoolsURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodFetchTopPools, req, &r)
+	if err != nil {
+		return nil, err
+	}
 	return r.TopPools, nil
 }
 
 func (as *HTTPAccountService) RetrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolDetails, error) {
-	if as.UseApi {
-		return retrievePoolDetails(ctx, sym)
+	if as.routeLocal(remote.MethodRetrievePoolDetails) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.RetrievePoolDetails(ctx, sym)
+	}
+	if as.UseApi || as.Routes[remote.MethodRetrievePoolDetails] == RouteAPI {
+		return as.retrievePoolDetails(ctx, sym)
 	} else {
 		return nil, nil
 	}
 }
 
-func retrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolDetails, error) {
+func (as *HTTPAccountService) retrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolDetails, error) {
 	var r struct {
 		PoolDetails dataserviceapi.PoolDetails `json:"poolDetails"`
 	}
 
-	ep, err := url.JoinPath(config.RetrievePoolDetailsURL, sym)
+	ep, err := url.JoinPath(as.config().RetrievePoolDetailsURL, sym)
 	if err != nil {
 		return nil, err
 	}
@@ -338,7 +519,7 @@ func retrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolD
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodRetrievePoolDetails, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -347,6 +528,14 @@ func retrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolD
 }
 
 func (as *HTTPAccountService) PoolDeposit(ctx context.Context, amount, from, poolAddress, tokenAddress string) (*models.PoolDepositResult, error) {
+	if as.routeLocal(remote.MethodPoolDeposit) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.PoolDeposit(ctx, amount, from, poolAddress, tokenAddress)
+	}
+
 	var r models.PoolDepositResult
 
 	//pool deposit payload
@@ -361,11 +550,15 @@ func (as *HTTPAccountService) PoolDeposit(ctx context.Context, amount, from, poo
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", config.PoolDepositURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", as.config().PoolDepositURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	err = setIdempotencyKey(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	_, err = as.doRequest(ctx, remote.MethodPoolDeposit, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -373,6 +566,14 @@ func (as *HTTPAccountService) PoolDeposit(ctx context.Context, amount, from, poo
 }
 
 func (as *HTTPAccountService) GetPoolSwapQuote(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapQuoteResult, error) {
+	if as.routeLocal(remote.MethodGetPoolSwapQuote) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.GetPoolSwapQuote(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	}
+
 	var r models.PoolSwapQuoteResult
 
 	//pool swap quote payload
@@ -389,11 +590,11 @@ func (as *HTTPAccountService) GetPoolSwapQuote(ctx context.Context, amount, from
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", config.PoolSwapQuoteURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", as.config().PoolSwapQuoteURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodGetPoolSwapQuote, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -401,10 +602,13 @@ func (as *HTTPAccountService) GetPoolSwapQuote(ctx context.Context, amount, from
 }
 
 func (as *HTTPAccountService) GetPoolSwappableFromVouchers(ctx context.Context, poolAddress, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
-	if as.UseApi {
+	if !as.routeLocal(remote.MethodGetPoolSwappableFromVouchers) {
 		return as.getPoolSwappableFromVouchers(ctx, poolAddress, publicKey)
 	} else {
-		svc := dev.NewDevAccountService(ctx, as.SS)
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.GetPoolSwappableFromVouchers(ctx, poolAddress, publicKey)
 	}
 }
@@ -413,7 +617,7 @@ func (as *HTTPAccountService) getPoolSwappableFromVouchers(ctx context.Context,
 	var r struct {
 		PoolSwappableVouchers []dataserviceapi.TokenHoldings `json:"filtered"`
 	}
-	ep, err := url.JoinPath(config.PoolSwappableVouchersURL, poolAddress, "from", publicKey)
+	ep, err := url.JoinPath(as.config().PoolSwappableVouchersURL, poolAddress, "from", publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -421,7 +625,7 @@ func (as *HTTPAccountService) getPoolSwappableFromVouchers(ctx context.Context,
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodGetPoolSwappableFromVouchers, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -435,20 +639,23 @@ func (as *HTTPAccountService) getPoolSwappableFromVouchers(ctx context.Context,
 }
 
 func (as *HTTPAccountService) GetPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
-	svc := dev.NewDevAccountService(ctx, as.SS)
-	if as.UseApi {
+	if !as.routeLocal(remote.MethodGetPoolSwappableVouchers) {
 		return as.getPoolSwappableVouchers(ctx, poolAddress)
 	} else {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.GetPoolSwappableVouchers(ctx, poolAddress)
 	}
 }
 
-func (as HTTPAccountService) getPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
+func (as *HTTPAccountService) getPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
 	var r struct {
 		PoolSwappableVouchers []dataserviceapi.TokenHoldings `json:"filtered"`
 	}
 
-	basePath, err := url.JoinPath(config.PoolSwappableVouchersURL, poolAddress, "to")
+	basePath, err := url.JoinPath(as.config().PoolSwappableVouchersURL, poolAddress, "to")
 	if err != nil {
 		return nil, err
 	}
@@ -459,8 +666,8 @@ func (as HTTPAccountService) getPoolSwappableVouchers(ctx context.Context, poolA
 	}
 
 	query := parsedURL.Query()
-	if config.IncludeStablesParam != "" {
-		query.Set("stables", config.IncludeStablesParam)
+	if as.config().IncludeStablesParam != "" {
+		query.Set("stables", as.config().IncludeStablesParam)
 	}
 	parsedURL.RawQuery = query.Encode()
 
@@ -469,7 +676,7 @@ func (as HTTPAccountService) getPoolSwappableVouchers(ctx context.Context, poolA
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodGetPoolSwappableVouchers, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -483,6 +690,14 @@ func (as HTTPAccountService) getPoolSwappableVouchers(ctx context.Context, poolA
 }
 
 func (as *HTTPAccountService) PoolSwap(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapResult, error) {
+	if as.routeLocal(remote.MethodPoolSwap) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.PoolSwap(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	}
+
 	var r models.PoolSwapResult
 
 	//swap payload
@@ -499,11 +714,15 @@ func (as *HTTPAccountService) PoolSwap(ctx context.Context, amount, from, fromTo
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", config.PoolSwapURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", as.config().PoolSwapURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, err
+	}
+	err = setIdempotencyKey(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodPoolSwap, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -511,10 +730,13 @@ func (as *HTTPAccountService) PoolSwap(ctx context.Context, amount, from, fromTo
 }
 
 func (as *HTTPAccountService) GetSwapFromTokenMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.MaxLimitResult, error) {
-	if as.UseApi {
+	if !as.routeLocal(remote.MethodGetSwapFromTokenMaxLimit) {
 		return as.getSwapFromTokenMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
 	} else {
-		svc := dev.NewDevAccountService(ctx, as.SS)
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.GetSwapFromTokenMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
 	}
 }
@@ -522,7 +744,7 @@ func (as *HTTPAccountService) GetSwapFromTokenMaxLimit(ctx context.Context, pool
 func (as *HTTPAccountService) getSwapFromTokenMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.MaxLimitResult, error) {
 	var r models.MaxLimitResult
 
-	ep, err := url.JoinPath(config.PoolSwappableVouchersURL, poolAddress, "limit", fromTokenAddress, toTokenAddress, publicKey)
+	ep, err := url.JoinPath(as.config().PoolSwappableVouchersURL, poolAddress, "limit", fromTokenAddress, toTokenAddress, publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -530,7 +752,7 @@ func (as *HTTPAccountService) getSwapFromTokenMaxLimit(ctx context.Context, pool
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodGetSwapFromTokenMaxLimit, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -539,10 +761,13 @@ func (as *HTTPAccountService) getSwapFromTokenMaxLimit(ctx context.Context, pool
 }
 
 func (as *HTTPAccountService) CheckTokenInPool(ctx context.Context, poolAddress, tokenAddress string) (*models.TokenInPoolResult, error) {
-	if as.UseApi {
+	if !as.routeLocal(remote.MethodCheckTokenInPool) {
 		return as.checkTokenInPool(ctx, poolAddress, tokenAddress)
 	} else {
-		svc := dev.NewDevAccountService(ctx, as.SS)
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.CheckTokenInPool(ctx, poolAddress, tokenAddress)
 	}
 }
@@ -550,7 +775,7 @@ func (as *HTTPAccountService) CheckTokenInPool(ctx context.Context, poolAddress,
 func (as *HTTPAccountService) checkTokenInPool(ctx context.Context, poolAddress, tokenAddress string) (*models.TokenInPoolResult, error) {
 	var r models.TokenInPoolResult
 
-	ep, err := url.JoinPath(config.PoolSwappableVouchersURL, poolAddress, "check", tokenAddress)
+	ep, err := url.JoinPath(as.config().PoolSwappableVouchersURL, poolAddress, "check", tokenAddress)
 	if err != nil {
 		return nil, err
 	}
@@ -558,7 +783,7 @@ func (as *HTTPAccountService) checkTokenInPool(ctx context.Context, poolAddress,
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodCheckTokenInPool, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -568,30 +793,30 @@ func (as *HTTPAccountService) checkTokenInPool(ctx context.Context, poolAddress,
 
 // TODO: Use actual custodial api to request available alias
 func (as *HTTPAccountService) RequestAlias(ctx context.Context, publicKey string, hint string) (*models.RequestAliasResult, error) {
-	if as.SS == nil {
-		return nil, fmt.Errorf("The storage service cannot be nil")
-	}
-	if as.UseApi {
+	if !as.routeLocal(remote.MethodRequestAlias) {
 		if !strings.Contains(hint, ".") {
 			hint = as.ToFqdn(hint)
 		}
-		enr, err := requestEnsAlias(ctx, publicKey, hint)
+		enr, err := as.requestEnsAlias(ctx, publicKey, hint)
 		if err != nil {
 			return nil, err
 		}
 		return &models.RequestAliasResult{Alias: enr.Name}, nil
 	} else {
-		svc := dev.NewDevAccountService(ctx, as.SS)
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.RequestAlias(ctx, publicKey, hint)
 	}
 }
 
-func requestEnsAlias(ctx context.Context, publicKey string, hint string) (*models.AliasEnsResult, error) {
+func (as *HTTPAccountService) requestEnsAlias(ctx context.Context, publicKey string, hint string) (*models.AliasEnsResult, error) {
 	var r models.AliasEnsResult
 
-	endpoint := config.AliasRegistrationURL
+	endpoint := as.config().AliasRegistrationURL
 
-	logg.InfoCtxf(ctx, "requesting alias", "endpoint", endpoint, "hint", hint)
+	logg.InfoCtxf(ctx, "requesting alias", "endpoint", endpoint, "hint", as.logPolicy().String(hint))
 	//Payload with the address and hint to derive an ENS name
 	payload := map[string]string{
 		"address": publicKey,
@@ -605,9 +830,7 @@ func requestEnsAlias(ctx context.Context, publicKey string, hint string) (*model
 	if err != nil {
 		return nil, err
 	}
-	// Log the request body
-	logg.InfoCtxf(ctx, "request body", "payload", string(payloadBytes))
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodRequestAlias, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -616,30 +839,30 @@ func requestEnsAlias(ctx context.Context, publicKey string, hint string) (*model
 }
 
 func (as *HTTPAccountService) UpdateAlias(ctx context.Context, name string, publicKey string) (*models.RequestAliasResult, error) {
-	if as.SS == nil {
-		return nil, fmt.Errorf("The storage service cannot be nil")
-	}
-	if as.UseApi {
+	if !as.routeLocal(remote.MethodUpdateAlias) {
 		if !strings.Contains(name, ".") {
 			name = as.ToFqdn(name)
 		}
-		enr, err := updateEnsAlias(ctx, name, publicKey)
+		enr, err := as.updateEnsAlias(ctx, name, publicKey)
 		if err != nil {
 			return nil, err
 		}
 		return &models.RequestAliasResult{Alias: enr.Name}, nil
 	} else {
-		svc := dev.NewDevAccountService(ctx, as.SS)
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return svc.RequestAlias(ctx, publicKey, name)
 	}
 }
 
-func updateEnsAlias(ctx context.Context, name string, publicKey string) (*models.AliasEnsResult, error) {
+func (as *HTTPAccountService) updateEnsAlias(ctx context.Context, name string, publicKey string) (*models.AliasEnsResult, error) {
 	var r models.AliasEnsResult
 
-	endpoint := config.AliasUpdateURL
+	endpoint := as.config().AliasUpdateURL
 
-	logg.InfoCtxf(ctx, "updating alias", "endpoint", endpoint, "name", name)
+	logg.InfoCtxf(ctx, "updating alias", "endpoint", endpoint, "name", as.logPolicy().String(name))
 
 	payload := map[string]string{
 		"name":    name,
@@ -653,9 +876,7 @@ func updateEnsAlias(ctx context.Context, name string, publicKey string) (*models
 	if err != nil {
 		return nil, err
 	}
-	// Log the request body
-	logg.InfoCtxf(ctx, "request body", "payload", string(payloadBytes))
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodUpdateAlias, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -668,6 +889,14 @@ func updateEnsAlias(ctx context.Context, name string, publicKey string) (*models
 //   - inviterPhone: The user initiating the SMS.
 //   - inviteePhone: The number being invited to Sarafu.
 func (as *HTTPAccountService) SendUpsellSMS(ctx context.Context, inviterPhone, inviteePhone string) (*models.SendSMSResponse, error) {
+	if as.routeLocal(remote.MethodSendUpsellSMS) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.SendUpsellSMS(ctx, inviterPhone, inviteePhone)
+	}
+
 	var r models.SendSMSResponse
 
 	// Create request payload
@@ -682,11 +911,11 @@ func (as *HTTPAccountService) SendUpsellSMS(ctx context.Context, inviterPhone, i
 	}
 
 	// Create a new request
-	req, err := http.NewRequest("POST", config.SendSMSURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", as.config().SendSMSURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodSendUpsellSMS, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -695,11 +924,18 @@ func (as *HTTPAccountService) SendUpsellSMS(ctx context.Context, inviterPhone, i
 }
 
 func (as *HTTPAccountService) SendAddressSMS(ctx context.Context, publicKey, originPhone string) error {
-	ep, err := url.JoinPath(config.ExternalSMSURL, "address")
+	if as.routeLocal(remote.MethodSendAddressSMS) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return err
+		}
+		return svc.SendAddressSMS(ctx, publicKey, originPhone)
+	}
+	ep, err := url.JoinPath(as.config().ExternalSMSURL, "address")
 	if err != nil {
 		return err
 	}
-	logg.InfoCtxf(ctx, "sending an address sms", "endpoint", ep, "address", publicKey, "origin-phone", originPhone)
+	logg.InfoCtxf(ctx, "sending an address sms", "endpoint", ep, "address", as.logPolicy().String(publicKey), "origin-phone", as.logPolicy().String(originPhone))
 	payload := map[string]string{
 		"address":     publicKey,
 		"originPhone": originPhone,
@@ -712,7 +948,7 @@ func (as *HTTPAccountService) SendAddressSMS(ctx context.Context, publicKey, ori
 	if err != nil {
 		return err
 	}
-	_, err = doRequest(ctx, req, nil)
+	_, err = as.doRequest(ctx, remote.MethodSendAddressSMS, req, nil)
 	if err != nil {
 		return err
 	}
@@ -720,11 +956,18 @@ func (as *HTTPAccountService) SendAddressSMS(ctx context.Context, publicKey, ori
 }
 
 func (as *HTTPAccountService) SendPINResetSMS(ctx context.Context, admin, phone string) error {
-	ep, err := url.JoinPath(config.ExternalSMSURL, "pinreset")
+	if as.routeLocal(remote.MethodSendPINResetSMS) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return err
+		}
+		return svc.SendPINResetSMS(ctx, admin, phone)
+	}
+	ep, err := url.JoinPath(as.config().ExternalSMSURL, "pinreset")
 	if err != nil {
 		return err
 	}
-	logg.InfoCtxf(ctx, "sending pin reset sms", "endpoint", ep, "admin", admin, "phone", phone)
+	logg.InfoCtxf(ctx, "sending pin reset sms", "endpoint", ep, "admin", as.logPolicy().String(admin), "phone", as.logPolicy().String(phone))
 	payload := map[string]string{
 		"admin": admin,
 		"phone": phone,
@@ -737,7 +980,7 @@ func (as *HTTPAccountService) SendPINResetSMS(ctx context.Context, admin, phone
 	if err != nil {
 		return err
 	}
-	_, err = doRequest(ctx, req, nil)
+	_, err = as.doRequest(ctx, remote.MethodSendPINResetSMS, req, nil)
 	if err != nil {
 		return err
 	}
@@ -746,9 +989,17 @@ func (as *HTTPAccountService) SendPINResetSMS(ctx context.Context, admin, phone
 
 // GetCreditSendMaxLimit calls the API to check credit limits and return the maxRAT and maxSAT
 func (as *HTTPAccountService) GetCreditSendMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.CreditSendLimitsResult, error) {
+	if as.routeLocal(remote.MethodGetCreditSendMaxLimit) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.GetCreditSendMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	}
+
 	var r models.CreditSendLimitsResult
 
-	ep, err := url.JoinPath(config.CreditSendURL, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	ep, err := url.JoinPath(as.config().CreditSendURL, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -756,7 +1007,7 @@ func (as *HTTPAccountService) GetCreditSendMaxLimit(ctx context.Context, poolAdd
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodGetCreditSendMaxLimit, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -766,9 +1017,17 @@ func (as *HTTPAccountService) GetCreditSendMaxLimit(ctx context.Context, poolAdd
 
 // GetCreditSendReverseQuote calls the API to getthe reverse quote for sending RAT amount
 func (as *HTTPAccountService) GetCreditSendReverseQuote(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount string) (*models.CreditSendReverseQouteResult, error) {
+	if as.routeLocal(remote.MethodGetCreditSendReverseQuote) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.GetCreditSendReverseQuote(ctx, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount)
+	}
+
 	var r models.CreditSendReverseQouteResult
 
-	ep, err := url.JoinPath(config.CreditSendReverseQuoteURL, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount)
+	ep, err := url.JoinPath(as.config().CreditSendReverseQuoteURL, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount)
 	if err != nil {
 		return nil, err
 	}
@@ -776,7 +1035,7 @@ func (as *HTTPAccountService) GetCreditSendReverseQuote(ctx context.Context, poo
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = as.doRequest(ctx, remote.MethodGetCreditSendReverseQuote, req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -791,9 +1050,17 @@ func (as *HTTPAccountService) GetCreditSendReverseQuote(ctx context.Context, poo
 //   - asset: the intented USD voucher "USDT | USDC | cUSD"
 //   - amount: The amount in Kenyan shillings
 func (as *HTTPAccountService) MpesaTriggerOnramp(ctx context.Context, address, phoneNumber, asset string, amount int) (*models.MpesaOnrampResponse, error) {
+	if as.routeLocal(remote.MethodMpesaTriggerOnramp) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.MpesaTriggerOnramp(ctx, address, phoneNumber, asset, amount)
+	}
+
 	var r models.MpesaOnrampResponse
 
-	ctx = context.WithValue(ctx, ctxKeyAuthToken, config.MpesaOnrampBearerToken)
+	ctx = context.WithValue(ctx, ctxKeyAuthToken, as.config().MpesaOnrampBearerToken)
 
 	// Prepare payload
 	payload := struct {
@@ -813,12 +1080,12 @@ func (as *HTTPAccountService) MpesaTriggerOnramp(ctx context.Context, address, p
 		return nil, fmt.Errorf("failed to marshal mpesa onramp payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", config.MpesaOnrampURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", as.config().MpesaOnrampURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := doRequest(ctx, req, &r); err != nil {
+	if _, err := as.doRequest(ctx, remote.MethodMpesaTriggerOnramp, req, &r); err != nil {
 		return nil, err
 	}
 
@@ -827,73 +1094,130 @@ func (as *HTTPAccountService) MpesaTriggerOnramp(ctx context.Context, address, p
 
 // GetMpesaOnrampRates calls the API to fetch the buying and selling rates for KSH.
 func (as *HTTPAccountService) GetMpesaOnrampRates(ctx context.Context) (*models.MpesaOnrampRatesResponse, error) {
+	if as.routeLocal(remote.MethodGetMpesaOnrampRates) {
+		svc, err := as.local(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return svc.GetMpesaOnrampRates(ctx)
+	}
+
 	var r models.MpesaOnrampRatesResponse
 
-	ctx = context.WithValue(ctx, ctxKeyAuthToken, config.MpesaOnrampBearerToken)
+	ctx = context.WithValue(ctx, ctxKeyAuthToken, as.config().MpesaOnrampBearerToken)
 
-	req, err := http.NewRequest("GET", config.MpresaOnrampRatesURL, nil)
+	req, err := http.NewRequest("GET", as.config().MpesaOnrampRatesURL, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := doRequest(ctx, req, &r); err != nil {
+	if _, err := as.doRequest(ctx, remote.MethodGetMpesaOnrampRates, req, &r); err != nil {
 		return nil, err
 	}
 
 	return &r, nil
 }
 
+// setIdempotencyKey sets the Idempotency-Key header on a write request, using
+// the key from ctx if the caller supplied one and a random one otherwise.
+func setIdempotencyKey(ctx context.Context, req *http.Request) error {
+	key, ok := remote.IdempotencyKey(ctx)
+	if !ok {
+		uid, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		key = uid.String()
+	}
+	req.Header.Set(remote.IdempotencyKeyHeader, key)
+	return nil
+}
+
 // TODO: remove eth-custodial api dependency
-func doRequest(ctx context.Context, req *http.Request, rcpt any) (*api.OKResponse, error) {
+func (as *HTTPAccountService) doRequest(ctx context.Context, op string, req *http.Request, rcpt any) (*api.OKResponse, error) {
 	var okResponse api.OKResponse
 	var errResponse api.ErrResponse
+	var reqBody []byte
+	var err error
+
+	ctx, cancel := as.withTimeout(ctx, op)
+	defer cancel()
+	req = req.WithContext(ctx)
 
 	// Check if a custom Authorization token was provided
 	if token, ok := ctx.Value(ctxKeyAuthToken).(string); ok && token != "" {
 		req.Header.Set("Authorization", "Bearer "+token)
 	} else {
-		req.Header.Set("Authorization", "Bearer "+config.BearerToken)
+		req.Header.Set("Authorization", "Bearer "+as.config().BearerToken)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	// Log request
-	logRequestDetails(req)
+	if req.Body != nil {
+		reqBody, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewBuffer(reqBody)) // Restore body
+	}
+	as.logRequest(ctx, op, req, reqBody)
 
-	resp, err := http.DefaultClient.Do(req)
+	upstream := upstreamOf(req.URL)
+	err = as.breaker().allow(upstream)
 	if err != nil {
-		log.Printf("Failed to make %s request to endpoint: %s with reason: %s", req.Method, req.URL, err.Error())
-		errResponse.Description = err.Error()
-		return nil, err
+		logg.WarnCtxf(ctx, "upstream unavailable, request not sent", "upstream", upstream, "op", op)
+		return nil, &APIError{
+			Kind:     remote.ErrUpstreamUnavailable,
+			Endpoint: req.URL.String(),
+			Err:      err,
+			Unsent:   true,
+		}
 	}
-	defer resp.Body.Close()
-
-	// Read and log response body
-	body, err := io.ReadAll(resp.Body)
+	resp, body, err := as.doWithRetry(ctx, req)
+	as.breaker().record(ctx, upstream, resp, err)
+	as.countResponse(op, upstream, resp, err)
 	if err != nil {
-		return nil, err
+		p := as.logPolicy()
+		logg.WarnCtxf(ctx, "request failed", "op", op, "method", req.Method, "url", p.URL(op, req.URL), "err", p.Error(err))
+		return nil, newTransportError(req, err)
 	}
 
-	log.Printf("Received response for %s: Status Code: %d | Content-Type: %s | Body: %s",
-		req.URL, resp.StatusCode, resp.Header.Get("Content-Type"), string(body))
+	as.logResponse(ctx, op, req, resp, body)
 
 	if resp.StatusCode >= http.StatusBadRequest {
-		if err := json.Unmarshal(body, &errResponse); err != nil {
-			return nil, err
+		apiErr := &APIError{
+			Status:   resp.StatusCode,
+			Endpoint: req.URL.String(),
 		}
-
-		return nil, &APIError{
-			Code:        errResponse.ErrCode,
-			Description: errResponse.Description,
+		if err := json.Unmarshal(body, &errResponse); err != nil {
+			// not an api error envelope, e.g. from a proxy in front of the service
+			apiErr.Kind = statusErrKind(resp.StatusCode)
+			apiErr.Description = http.StatusText(resp.StatusCode)
+			apiErr.Err = err
+			return nil, apiErr
 		}
+		apiErr.Kind = errKind(op, resp.StatusCode, errResponse.ErrCode, errResponse.Description)
+		apiErr.Code = errResponse.ErrCode
+		apiErr.Description = errResponse.Description
+		return nil, apiErr
 	}
 
 	if err := json.Unmarshal(body, &okResponse); err != nil {
-		return nil, err
+		return nil, &APIError{
+			Kind:     remote.ErrBadResponse,
+			Status:   resp.StatusCode,
+			Endpoint: req.URL.String(),
+			Err:      err,
+		}
 	}
 
 	if len(okResponse.Result) == 0 {
-		return nil, errors.New("empty api result")
+		return nil, &APIError{
+			Kind:        remote.ErrBadResponse,
+			Description: "empty api result",
+			Status:      resp.StatusCode,
+			Endpoint:    req.URL.String(),
+		}
 	}
 
 	v, err := json.Marshal(okResponse.Result)
@@ -902,20 +1226,13 @@ func doRequest(ctx context.Context, req *http.Request, rcpt any) (*api.OKRespons
 	}
 
 	err = json.Unmarshal(v, &rcpt)
-	return &okResponse, err
-}
-
-func logRequestDetails(req *http.Request) {
-	var bodyBytes []byte
-	contentType := req.Header.Get("Content-Type")
-
-	if req.Body != nil {
-		bodyBytes, _ = io.ReadAll(req.Body)
-		req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // Restore body
-	} else {
-		bodyBytes = []byte("-")
+	if err != nil {
+		return nil, &APIError{
+			Kind:     remote.ErrBadResponse,
+			Status:   resp.StatusCode,
+			Endpoint: req.URL.String(),
+			Err:      err,
+		}
 	}
-
-	log.Printf("Outgoing Request -> URL: %s | Method: %s | Content-Type: %s | Body: %s",
-		req.URL.String(), req.Method, contentType, string(bodyBytes))
+	return &okResponse, nil
 }
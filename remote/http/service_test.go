@@ -0,0 +1,490 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/config"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/dev"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/metrics"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	"git.grassecon.net/grassrootseconomics/visedriver/testutil/mocks"
+)
+
+func TestOperationTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.Write([]byte(`{"ok":true,"description":"","result":{"balance":"1","nonce":0}}`))
+	}))
+	defer srv.Close()
+	cfg := &config.Config{
+		BalanceURL: srv.URL,
+	}
+
+	svc := (&HTTPAccountService{}).WithClient(srv.Client()).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{})).WithTimeout(remote.MethodCheckBalance, 10*time.Millisecond)
+	_, err := svc.CheckBalance(context.Background(), "0xdeadbeef")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// a tighter deadline on ctx wins over the operation timeout
+	svc.WithTimeout(remote.MethodCheckBalance, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = svc.CheckBalance(ctx, "0xdeadbeef")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("ctx deadline not honored")
+	}
+}
+
+func TestRetryIdempotentOnly(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"ok":false,"description":"unavailable"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"description":"","result":{"balance":"42","nonce":0,"trackingId":"foo"}}`))
+	}))
+	defer srv.Close()
+	cfg := &config.Config{
+		BalanceURL:       srv.URL,
+		TokenTransferURL: srv.URL,
+	}
+
+	svc := (&HTTPAccountService{}).WithClient(srv.Client()).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{})).WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+	})
+	r, err := svc.CheckBalance(context.Background(), "0xdeadbeef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != "42" {
+		t.Fatalf("expected balance 42, got %s", r.Balance)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	_, err = svc.TokenTransfer(context.Background(), "1", "0xdeadbeef", "0xbeeffeed", "0xcafe")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected write to be sent once, got %d calls", calls.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"ok":false,"description":"boom","errorCode":"E01"}`))
+	}))
+	defer srv.Close()
+	cfg := &config.Config{
+		BalanceURL: srv.URL,
+	}
+
+	now := time.Now()
+	cb := NewCircuitBreaker(BreakerPolicy{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+	})
+	cb.now = func() time.Time {
+		return now
+	}
+	svc := (&HTTPAccountService{}).WithClient(srv.Client()).WithConfig(cfg).WithRetryPolicy(NoRetry).WithCircuitBreaker(cb)
+	for i := 0; i < 2; i++ {
+		_, err := svc.CheckBalance(context.Background(), "0xdeadbeef")
+		if err == nil || errors.Is(err, remote.ErrUpstreamUnavailable) {
+			t.Fatalf("expected upstream error, got %v", err)
+		}
+	}
+	_, err := svc.CheckBalance(context.Background(), "0xdeadbeef")
+	if !errors.Is(err, remote.ErrUpstreamUnavailable) {
+		t.Fatalf("expected upstream unavailable, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected request not to be sent while open, got %d calls", calls.Load())
+	}
+
+	// after the open timeout a single probe goes through and reopens on failure
+	now = now.Add(2 * time.Minute)
+	_, err = svc.CheckBalance(context.Background(), "0xdeadbeef")
+	if errors.Is(err, remote.ErrUpstreamUnavailable) {
+		t.Fatalf("expected probe to be sent")
+	}
+	_, err = svc.CheckBalance(context.Background(), "0xdeadbeef")
+	if !errors.Is(err, remote.ErrUpstreamUnavailable) {
+		t.Fatalf("expected upstream unavailable, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/holdings/0xdeadbeef":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ok":false,"description":"Not found"}`))
+		case "/balance/0xdeadbeef":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok":false,"description":"Account does not exist","errorCode":"E05"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`<html>nope</html>`))
+		}
+	}))
+	defer srv.Close()
+	cfg := &config.Config{
+		VoucherHoldingsURL: srv.URL + "/holdings",
+		BalanceURL:         srv.URL + "/balance",
+		TrackURL:           srv.URL + "/track",
+	}
+
+	reg := metrics.NewRegistry()
+	svc := (&HTTPAccountService{}).WithClient(srv.Client()).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{})).WithMetrics(reg)
+
+	var apiErr *APIError
+	_, err := svc.FetchVouchers(context.Background(), "0xdeadbeef")
+	if !errors.Is(err, remote.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %T", err)
+	}
+	if apiErr.Status != http.StatusNotFound || apiErr.Endpoint != srv.URL+"/holdings/0xdeadbeef" {
+		t.Fatalf("unexpected error details: %+v", apiErr)
+	}
+
+	_, err = svc.CheckBalance(context.Background(), "0xdeadbeef")
+	if !errors.Is(err, remote.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err.Error() != "[E05] Account does not exist" {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+
+	_, err = svc.TrackAccountStatus(context.Background(), "0xdeadbeef")
+	if !errors.Is(err, remote.ErrUnauthorized) {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+
+	responses := reg.Counter(ResponsesMetric, "", "op", "upstream", "status")
+	if n := responses.Value(remote.MethodFetchVouchers, srv.URL, "404"); n != 1 {
+		t.Fatalf("expected 1 not found response counted, got %v", n)
+	}
+	if n := responses.Value(remote.MethodTrackAccountStatus, srv.URL, "401"); n != 1 {
+		t.Fatalf("expected 1 unauthorized response counted, got %v", n)
+	}
+}
+
+func TestUnsent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer srv.Close()
+	cfg := &config.Config{
+		TokenTransferURL: srv.URL,
+	}
+	svc := (&HTTPAccountService{}).WithClient(srv.Client()).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{}))
+
+	// a gateway timeout may come after the upstream acted on the request
+	_, err := svc.TokenTransfer(context.Background(), "1", "0xdeadbeef", "0xbeeffeed", "0xcafe")
+	if !errors.Is(err, remote.ErrUpstreamUnavailable) || remote.Unsent(err) {
+		t.Fatalf("expected unavailable error not marked unsent, got %v", err)
+	}
+
+	// as may a response that did not arrive in time
+	cfg.TokenTransferURL = srv.URL + "/slow"
+	svc.WithTimeout(remote.MethodTokenTransfer, 10*time.Millisecond)
+	_, err = svc.TokenTransfer(context.Background(), "1", "0xdeadbeef", "0xbeeffeed", "0xcafe")
+	if !errors.Is(err, context.DeadlineExceeded) || remote.Unsent(err) {
+		t.Fatalf("expected timeout not marked unsent, got %v", err)
+	}
+
+	// a refused connection means the request was never received
+	srv.Close()
+	cfg.TokenTransferURL = srv.URL
+	_, err = svc.TokenTransfer(context.Background(), "1", "0xdeadbeef", "0xbeeffeed", "0xcafe")
+	if !remote.Unsent(err) {
+		t.Fatalf("expected refused connection marked unsent, got %v", err)
+	}
+}
+
+func TestLogPolicyRedaction(t *testing.T) {
+	p := DefaultLogPolicy
+	body := p.Body([]byte(`{"phoneNumber":"+254712345678","amount":"1000","address":"0xeae046BF396e91f5A8D74f863dC57c107c8a4a70","token":"s3cr3t","note":"call +254712345678"}`))
+	for _, leak := range []string{"+254712345678", "1000", "s3cr3t"} {
+		if strings.Contains(body, leak) {
+			t.Fatalf("expected '%s' to be redacted in %s", leak, body)
+		}
+	}
+	if !strings.Contains(body, "0xeae046BF396e91f5A8D74f863dC57c107c8a4a70") {
+		t.Fatalf("expected address to be kept by default in %s", body)
+	}
+	if !strings.Contains(body, "678") {
+		t.Fatalf("expected phone suffix to be kept in %s", body)
+	}
+
+	p.RedactAddresses = true
+	s := p.String("/api/v1/holdings/0xeae046BF396e91f5A8D74f863dC57c107c8a4a70")
+	if s != "/api/v1/holdings/"+redacted {
+		t.Fatalf("unexpected redacted url %s", s)
+	}
+
+	// path parameters are logged as the route template
+	u, _ := url.Parse("http://localhost/api/v1/credit-send/reverse/0xpool/0xfrom/0xto/1000")
+	s = p.URL(remote.MethodGetCreditSendReverseQuote, u)
+	if s != "http://localhost/api/v1/credit-send/reverse/{poolAddress}/{fromTokenAddress}/{toTokenAddress}/{amount}" {
+		t.Fatalf("unexpected route %s", s)
+	}
+	u, _ = url.Parse("http://localhost/api/v1/resolve/alice.sarafu.eth")
+	s = p.URL(remote.MethodCheckAliasAddress, u)
+	if strings.Contains(s, "alice") {
+		t.Fatalf("expected alias to be left out of %s", s)
+	}
+
+	err := &url.Error{
+		Op:  "Get",
+		URL: "http://localhost/api/v1/resolve/alice.sarafu.eth",
+		Err: errors.New("connection reset"),
+	}
+	if s := p.Error(err); s != "connection reset" {
+		t.Fatalf("expected url left out of error, got %s", s)
+	}
+}
+
+func TestInstanceConfig(t *testing.T) {
+	newServer := func(balance string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+balance {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"ok":true,"description":"","result":{"balance":"` + balance + `","nonce":0}}`))
+		}))
+	}
+	staging := newServer("13")
+	defer staging.Close()
+	production := newServer("42")
+	defer production.Close()
+
+	for _, srv := range []*httptest.Server{staging, production} {
+		token := "13"
+		if srv == production {
+			token = "42"
+		}
+		cfg, err := config.NewConfig(config.Config{
+			CustodialURLBase: srv.URL,
+			DataURLBase:      srv.URL,
+			AliasEnsURLBase:  srv.URL,
+			ExternalSMSBase:  srv.URL,
+			MpesaOnrampBase:  srv.URL,
+			BearerToken:      token,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc := (&HTTPAccountService{}).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{}))
+		r, err := svc.CheckBalance(context.Background(), "0xdeadbeef")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Balance != token {
+			t.Fatalf("expected balance %s, got %s", token, r.Balance)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	cfg, err := config.NewConfig(config.Config{
+		CustodialURLBase: srv.URL,
+		DataURLBase:      srv.URL,
+		AliasEnsURLBase:  srv.URL,
+		ExternalSMSBase:  srv.URL,
+		MpesaOnrampBase:  down.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := (&HTTPAccountService{}).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{}))
+	r := svc.Health(context.Background())
+	if len(r) != 5 {
+		t.Fatalf("expected 5 upstreams, got %d", len(r))
+	}
+	for name, err := range r {
+		if name == "mpesa_onramp" {
+			if err == nil {
+				t.Fatalf("expected %s to be down", name)
+			}
+		} else if err != nil {
+			t.Fatalf("expected %s to be up, got %v", name, err)
+		}
+	}
+	err = svc.Ping(context.Background())
+	if !errors.Is(err, remote.ErrUpstreamUnavailable) {
+		t.Fatalf("expected upstream unavailable, got %v", err)
+	}
+}
+
+func TestDevServer(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewUnstartedServer(nil)
+	base := "http://" + ts.Listener.Addr().String()
+	cfg, err := config.NewConfig(config.Config{
+		CustodialURLBase: base,
+		DataURLBase:      base,
+		AliasEnsURLBase:  base,
+		ExternalSMSBase:  base,
+		MpesaOnrampBase:  base,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	das := dev.NewDevAccountService(ctx, mocks.NewMemStorageService(ctx)).WithAutoVoucher(ctx, "FOO", 42)
+	srv, err := dev.NewServer(das, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Config.Handler = srv
+	ts.Start()
+	defer ts.Close()
+
+	svc := (&HTTPAccountService{}).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(DefaultBreakerPolicy))
+	alice, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holdings, err := svc.FetchVouchers(ctx, alice.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holdings) != 1 || holdings[0].TokenSymbol != "FOO" {
+		t.Fatalf("unexpected holdings %v", holdings)
+	}
+
+	_, err = svc.TokenTransfer(ctx, "40", alice.PublicKey, bob.PublicKey, holdings[0].TokenAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.TokenTransfer(ctx, "40", alice.PublicKey, bob.PublicKey, holdings[0].TokenAddress)
+	if !errors.Is(err, remote.ErrInsufficientBalance) {
+		t.Fatalf("expected insufficient balance, got %v", err)
+	}
+	r, err := svc.CheckBalance(ctx, bob.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != "82" {
+		t.Fatalf("expected balance 82, got %s", r.Balance)
+	}
+	_, err = svc.CheckBalance(ctx, "0xdeadbeef")
+	if !errors.Is(err, remote.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"ok": true, "result": {"address": "0xapi"}}`))
+	}))
+	defer srv.Close()
+	cfg, err := config.NewConfig(config.Config{
+		CustodialURLBase: srv.URL,
+		DataURLBase:      srv.URL,
+		AliasEnsURLBase:  srv.URL,
+		ExternalSMSBase:  srv.URL,
+		MpesaOnrampBase:  srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// instances on the same storage share the local service
+	ss := mocks.NewMemStorageService(ctx)
+	svc := (&HTTPAccountService{SS: ss}).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{}))
+	other := &HTTPAccountService{SS: ss}
+	local, err := svc.local(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherLocal, err := other.local(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local != otherLocal {
+		t.Fatalf("expected shared local service")
+	}
+
+	das := dev.NewDevAccountService(ctx, mocks.NewMemStorageService(ctx))
+	acc, err := das.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = das.RequestAlias(ctx, acc.PublicKey, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc = (&HTTPAccountService{}).WithConfig(cfg).WithCircuitBreaker(NewCircuitBreaker(BreakerPolicy{})).WithLocal(das)
+	r, err := svc.CheckAliasAddress(ctx, "foo.sarafu.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Address != acc.PublicKey || calls.Load() != 0 {
+		t.Fatalf("expected local alias lookup, got %s after %d calls", r.Address, calls.Load())
+	}
+	svc.WithRoute(remote.MethodCheckAliasAddress, RouteAPI)
+	r, err = svc.CheckAliasAddress(ctx, "foo.sarafu.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Address != "0xapi" || calls.Load() != 1 {
+		t.Fatalf("expected api alias lookup, got %s after %d calls", r.Address, calls.Load())
+	}
+	svc.WithRoute(remote.MethodTrackAccountStatus, RouteLocal)
+	_, err = svc.TrackAccountStatus(ctx, acc.PublicKey)
+	if err != nil || calls.Load() != 1 {
+		t.Fatalf("expected local account status, got %v after %d calls", err, calls.Load())
+	}
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"context"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+// DefaultTimeout applies to operations that have no entry in DefaultTimeouts
+// or HTTPAccountService.Timeouts.
+var DefaultTimeout = 10 * time.Second
+
+// DefaultTimeouts holds the per-operation request timeouts used when
+// HTTPAccountService.Timeouts has no entry for an operation.
+//
+// Lookups that are shown on most menus are kept short, while writes that
+// wait on the custodial backend to build and queue a transaction get more time.
+var DefaultTimeouts = map[string]time.Duration{
+	remote.MethodCheckBalance:       5 * time.Second,
+	remote.MethodTrackAccountStatus: 5 * time.Second,
+	remote.MethodTrackTransaction:   5 * time.Second,
+	remote.MethodCheckAliasAddress:  5 * time.Second,
+	remote.MethodCheckTokenInPool:   5 * time.Second,
+	remote.MethodCreateAccount:      20 * time.Second,
+	remote.MethodTokenTransfer:      20 * time.Second,
+	remote.MethodPoolDeposit:        20 * time.Second,
+	remote.MethodPoolSwap:           20 * time.Second,
+	remote.MethodMpesaTriggerOnramp: 20 * time.Second,
+}
+
+// timeoutFor returns the request timeout configured for the operation.
+func (as *HTTPAccountService) timeoutFor(op string) time.Duration {
+	if d, ok := as.Timeouts[op]; ok {
+		return d
+	}
+	if d, ok := DefaultTimeouts[op]; ok {
+		return d
+	}
+	return DefaultTimeout
+}
+
+// withTimeout derives a context bounded by the operation timeout.
+//
+// If ctx already carries an earlier deadline, that deadline is kept.
+func (as *HTTPAccountService) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	d := as.timeoutFor(op)
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
@@ -0,0 +1,173 @@
+package hybrid
+
+import (
+	"context"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+)
+
+func (h *AccountService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	return call(ctx, h, remote.MethodCheckBalance, func(ctx context.Context, svc remote.AccountService) (*models.BalanceResult, error) {
+		return svc.CheckBalance(ctx, publicKey)
+	})
+}
+
+func (h *AccountService) CreateAccount(ctx context.Context) (*models.AccountResult, error) {
+	return call(ctx, h, remote.MethodCreateAccount, func(ctx context.Context, svc remote.AccountService) (*models.AccountResult, error) {
+		return svc.CreateAccount(ctx)
+	})
+}
+
+func (h *AccountService) TrackAccountStatus(ctx context.Context, publicKey string) (*models.TrackStatusResult, error) {
+	return call(ctx, h, remote.MethodTrackAccountStatus, func(ctx context.Context, svc remote.AccountService) (*models.TrackStatusResult, error) {
+		return svc.TrackAccountStatus(ctx, publicKey)
+	})
+}
+
+func (h *AccountService) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	return call(ctx, h, remote.MethodTrackTransaction, func(ctx context.Context, svc remote.AccountService) (*models.TrackTransactionResult, error) {
+		return svc.TrackTransaction(ctx, trackingId)
+	})
+}
+
+func (h *AccountService) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
+	return call(ctx, h, remote.MethodFetchVouchers, func(ctx context.Context, svc remote.AccountService) ([]dataserviceapi.TokenHoldings, error) {
+		return svc.FetchVouchers(ctx, publicKey)
+	})
+}
+
+func (h *AccountService) FetchTransactions(ctx context.Context, publicKey string) ([]dataserviceapi.Last10TxResponse, error) {
+	return call(ctx, h, remote.MethodFetchTransactions, func(ctx context.Context, svc remote.AccountService) ([]dataserviceapi.Last10TxResponse, error) {
+		return svc.FetchTransactions(ctx, publicKey)
+	})
+}
+
+func (h *AccountService) VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error) {
+	return call(ctx, h, remote.MethodVoucherData, func(ctx context.Context, svc remote.AccountService) (*models.VoucherDataResult, error) {
+		return svc.VoucherData(ctx, address)
+	})
+}
+
+func (h *AccountService) TokenTransfer(ctx context.Context, amount, from, to, tokenAddress string) (*models.TokenTransferResponse, error) {
+	return call(ctx, h, remote.MethodTokenTransfer, func(ctx context.Context, svc remote.AccountService) (*models.TokenTransferResponse, error) {
+		return svc.TokenTransfer(ctx, amount, from, to, tokenAddress)
+	})
+}
+
+func (h *AccountService) CheckAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
+	return call(ctx, h, remote.MethodCheckAliasAddress, func(ctx context.Context, svc remote.AccountService) (*models.AliasAddress, error) {
+		return svc.CheckAliasAddress(ctx, alias)
+	})
+}
+
+func (h *AccountService) RequestAlias(ctx context.Context, hint string, publicKey string) (*models.RequestAliasResult, error) {
+	return call(ctx, h, remote.MethodRequestAlias, func(ctx context.Context, svc remote.AccountService) (*models.RequestAliasResult, error) {
+		return svc.RequestAlias(ctx, hint, publicKey)
+	})
+}
+
+func (h *AccountService) UpdateAlias(ctx context.Context, name string, publicKey string) (*models.RequestAliasResult, error) {
+	return call(ctx, h, remote.MethodUpdateAlias, func(ctx context.Context, svc remote.AccountService) (*models.RequestAliasResult, error) {
+		return svc.UpdateAlias(ctx, name, publicKey)
+	})
+}
+
+func (h *AccountService) SendUpsellSMS(ctx context.Context, inviterPhone, inviteePhone string) (*models.SendSMSResponse, error) {
+	return call(ctx, h, remote.MethodSendUpsellSMS, func(ctx context.Context, svc remote.AccountService) (*models.SendSMSResponse, error) {
+		return svc.SendUpsellSMS(ctx, inviterPhone, inviteePhone)
+	})
+}
+
+func (h *AccountService) SendAddressSMS(ctx context.Context, publicKey, originPhone string) error {
+	_, err := call(ctx, h, remote.MethodSendAddressSMS, func(ctx context.Context, svc remote.AccountService) (struct{}, error) {
+		return struct{}{}, svc.SendAddressSMS(ctx, publicKey, originPhone)
+	})
+	return err
+}
+
+func (h *AccountService) SendPINResetSMS(ctx context.Context, admin, phone string) error {
+	_, err := call(ctx, h, remote.MethodSendPINResetSMS, func(ctx context.Context, svc remote.AccountService) (struct{}, error) {
+		return struct{}{}, svc.SendPINResetSMS(ctx, admin, phone)
+	})
+	return err
+}
+
+func (h *AccountService) PoolDeposit(ctx context.Context, amount, from, poolAddress, tokenAddress string) (*models.PoolDepositResult, error) {
+	return call(ctx, h, remote.MethodPoolDeposit, func(ctx context.Context, svc remote.AccountService) (*models.PoolDepositResult, error) {
+		return svc.PoolDeposit(ctx, amount, from, poolAddress, tokenAddress)
+	})
+}
+
+func (h *AccountService) FetchTopPools(ctx context.Context) ([]dataserviceapi.PoolDetails, error) {
+	return call(ctx, h, remote.MethodFetchTopPools, func(ctx context.Context, svc remote.AccountService) ([]dataserviceapi.PoolDetails, error) {
+		return svc.FetchTopPools(ctx)
+	})
+}
+
+func (h *AccountService) RetrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolDetails, error) {
+	return call(ctx, h, remote.MethodRetrievePoolDetails, func(ctx context.Context, svc remote.AccountService) (*dataserviceapi.PoolDetails, error) {
+		return svc.RetrievePoolDetails(ctx, sym)
+	})
+}
+
+func (h *AccountService) GetPoolSwappableFromVouchers(ctx context.Context, poolAddress, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
+	return call(ctx, h, remote.MethodGetPoolSwappableFromVouchers, func(ctx context.Context, svc remote.AccountService) ([]dataserviceapi.TokenHoldings, error) {
+		return svc.GetPoolSwappableFromVouchers(ctx, poolAddress, publicKey)
+	})
+}
+
+func (h *AccountService) GetPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
+	return call(ctx, h, remote.MethodGetPoolSwappableVouchers, func(ctx context.Context, svc remote.AccountService) ([]dataserviceapi.TokenHoldings, error) {
+		return svc.GetPoolSwappableVouchers(ctx, poolAddress)
+	})
+}
+
+func (h *AccountService) GetPoolSwapQuote(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapQuoteResult, error) {
+	return call(ctx, h, remote.MethodGetPoolSwapQuote, func(ctx context.Context, svc remote.AccountService) (*models.PoolSwapQuoteResult, error) {
+		return svc.GetPoolSwapQuote(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	})
+}
+
+func (h *AccountService) PoolSwap(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapResult, error) {
+	return call(ctx, h, remote.MethodPoolSwap, func(ctx context.Context, svc remote.AccountService) (*models.PoolSwapResult, error) {
+		return svc.PoolSwap(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	})
+}
+
+func (h *AccountService) GetSwapFromTokenMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.MaxLimitResult, error) {
+	return call(ctx, h, remote.MethodGetSwapFromTokenMaxLimit, func(ctx context.Context, svc remote.AccountService) (*models.MaxLimitResult, error) {
+		return svc.GetSwapFromTokenMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	})
+}
+
+func (h *AccountService) CheckTokenInPool(ctx context.Context, poolAddress, tokenAddress string) (*models.TokenInPoolResult, error) {
+	return call(ctx, h, remote.MethodCheckTokenInPool, func(ctx context.Context, svc remote.AccountService) (*models.TokenInPoolResult, error) {
+		return svc.CheckTokenInPool(ctx, poolAddress, tokenAddress)
+	})
+}
+
+func (h *AccountService) GetCreditSendMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.CreditSendLimitsResult, error) {
+	return call(ctx, h, remote.MethodGetCreditSendMaxLimit, func(ctx context.Context, svc remote.AccountService) (*models.CreditSendLimitsResult, error) {
+		return svc.GetCreditSendMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	})
+}
+
+func (h *AccountService) GetCreditSendReverseQuote(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount string) (*models.CreditSendReverseQouteResult, error) {
+	return call(ctx, h, remote.MethodGetCreditSendReverseQuote, func(ctx context.Context, svc remote.AccountService) (*models.CreditSendReverseQouteResult, error) {
+		return svc.GetCreditSendReverseQuote(ctx, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount)
+	})
+}
+
+func (h *AccountService) MpesaTriggerOnramp(ctx context.Context, address, phoneNumber, asset string, amount int) (*models.MpesaOnrampResponse, error) {
+	return call(ctx, h, remote.MethodMpesaTriggerOnramp, func(ctx context.Context, svc remote.AccountService) (*models.MpesaOnrampResponse, error) {
+		return svc.MpesaTriggerOnramp(ctx, address, phoneNumber, asset, amount)
+	})
+}
+
+func (h *AccountService) GetMpesaOnrampRates(ctx context.Context) (*models.MpesaOnrampRatesResponse, error) {
+	return call(ctx, h, remote.MethodGetMpesaOnrampRates, func(ctx context.Context, svc remote.AccountService) (*models.MpesaOnrampRatesResponse, error) {
+		return svc.GetMpesaOnrampRates(ctx)
+	})
+}
@@ -0,0 +1,314 @@
+// Package hybrid provides an AccountService that dispatches each operation to
+// one of several AccountService backends.
+package hybrid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("sarafu-api.hybrid")
+)
+
+const (
+	// DefaultShadowTimeout is the time given to a shadow call to complete.
+	DefaultShadowTimeout = 30 * time.Second
+	// DefaultMaxShadows is the default number of shadow calls running at the same time.
+	DefaultMaxShadows = 64
+)
+
+// Mode decides how an operation uses the backends of its route.
+type Mode int
+
+const (
+	// Single calls the primary backend only.
+	Single Mode = iota
+	// Fallback calls the secondary backend if the primary fails.
+	//
+	// Reads fall back on any error except those which are an answer from the
+	// primary, such as remote.ErrNotFound. Writes only fall back if the
+	// request is known not to have reached the primary, as reported by
+	// remote.Unsent, since they may otherwise have been applied by it.
+	Fallback
+	// Shadow also calls the secondary backend, in the background, and logs
+	// whether its outcome differs from that of the primary. The outcome of the
+	// primary is always returned. Writes are never shadowed.
+	Shadow
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Single:
+		return "single"
+	case Fallback:
+		return "fallback"
+	case Shadow:
+		return "shadow"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// Route selects the backends serving an operation.
+type Route struct {
+	// Primary is the name of the backend whose outcome is returned.
+	Primary string
+	Mode    Mode
+	// Secondary is the name of the fallback or shadow backend.
+	Secondary string
+}
+
+// Diff describes a shadow call whose outcome differed from that of the primary.
+type Diff struct {
+	Method    string
+	Primary   string
+	Secondary string
+	// PrimaryResult is a copy of the value returned to the caller, and
+	// SecondaryResult the value returned by the shadow backend. Both are nil
+	// for operations returning only an error.
+	PrimaryResult   any
+	SecondaryResult any
+	PrimaryErr      error
+	SecondaryErr    error
+}
+
+// answers are the error kinds which are a valid response from a backend, and
+// so are not worth asking another backend about.
+var answers = []error{
+	remote.ErrNotFound,
+	remote.ErrInsufficientBalance,
+	remote.ErrInvalidAlias,
+	remote.ErrValidation,
+}
+
+// AccountService is a remote.AccountService routing each operation to one or
+// two of a set of named backends.
+type AccountService struct {
+	backends      map[string]remote.AccountService
+	route         Route
+	routes        map[string]Route
+	onDiff        func(ctx context.Context, diff Diff)
+	shadowTimeout time.Duration
+	shadows       chan struct{}
+	dropped       atomic.Uint64
+}
+
+// NewAccountService creates an AccountService with the backend svc, named
+// name, serving all operations.
+func NewAccountService(name string, svc remote.AccountService) *AccountService {
+	return &AccountService{
+		backends: map[string]remote.AccountService{
+			name: svc,
+		},
+		route: Route{
+			Primary: name,
+		},
+		routes:        make(map[string]Route),
+		shadowTimeout: DefaultShadowTimeout,
+		shadows:       make(chan struct{}, DefaultMaxShadows),
+	}
+}
+
+// WithBackend adds a backend that routes can refer to by name.
+func (h *AccountService) WithBackend(name string, svc remote.AccountService) *AccountService {
+	h.backends[name] = svc
+	return h
+}
+
+// WithDefaultRoute sets the route of the operations without a route of their own.
+func (h *AccountService) WithDefaultRoute(route Route) *AccountService {
+	h.route = route
+	return h
+}
+
+// WithRoute sets the route of a single operation, named by one of the
+// remote.Method* constants.
+func (h *AccountService) WithRoute(method string, route Route) *AccountService {
+	h.routes[method] = route
+	return h
+}
+
+// WithDiffHandler sets a function called with every shadow call whose outcome
+// differs from that of the primary, in addition to the difference being logged.
+//
+// The function is called from the goroutine of the shadow call.
+func (h *AccountService) WithDiffHandler(fn func(ctx context.Context, diff Diff)) *AccountService {
+	h.onDiff = fn
+	return h
+}
+
+// WithShadowTimeout sets the time given to a shadow call to complete.
+func (h *AccountService) WithShadowTimeout(timeout time.Duration) *AccountService {
+	h.shadowTimeout = timeout
+	return h
+}
+
+// WithMaxShadows sets the number of shadow calls running at the same time.
+// Calls that would be shadowed while that many are running are not.
+func (h *AccountService) WithMaxShadows(n int) *AccountService {
+	h.shadows = make(chan struct{}, n)
+	return h
+}
+
+// ShadowsDropped returns the number of shadow calls not made because too many were running.
+func (h *AccountService) ShadowsDropped() uint64 {
+	return h.dropped.Load()
+}
+
+// Route returns the route of the operation.
+func (h *AccountService) Route(method string) Route {
+	route, ok := h.routes[method]
+	if !ok {
+		return h.route
+	}
+	return route
+}
+
+func (h *AccountService) backend(name string) (remote.AccountService, error) {
+	svc, ok := h.backends[name]
+	if !ok {
+		return nil, remote.NewError(remote.ErrInternal, "unknown backend %q", name)
+	}
+	return svc, nil
+}
+
+// shouldFallback reports whether the operation is to be tried on the secondary
+// backend after failing on the primary with err.
+func shouldFallback(method string, err error) bool {
+	if remote.IsWrite(method) {
+		return remote.Unsent(err)
+	}
+	for _, kind := range answers {
+		if errors.Is(err, kind) {
+			return false
+		}
+	}
+	return true
+}
+
+// call runs the operation on the backends of its route.
+func call[T any](ctx context.Context, h *AccountService, method string, fn func(ctx context.Context, svc remote.AccountService) (T, error)) (T, error) {
+	var zero T
+
+	route := h.Route(method)
+	svc, err := h.backend(route.Primary)
+	if err != nil {
+		return zero, err
+	}
+	r, err := fn(ctx, svc)
+
+	switch route.Mode {
+	case Fallback:
+		if err == nil || !shouldFallback(method, err) {
+			return r, err
+		}
+		secondary, serr := h.backend(route.Secondary)
+		if serr != nil {
+			logg.ErrorCtxf(ctx, "fallback backend unavailable", "method", method, "err", serr)
+			return r, err
+		}
+		logg.WarnCtxf(ctx, "primary backend failed, falling back", "method", method, "primary", route.Primary, "secondary", route.Secondary, "err", err)
+		return fn(ctx, secondary)
+	case Shadow:
+		if remote.IsWrite(method) {
+			logg.DebugCtxf(ctx, "not shadowing write", "method", method)
+			return r, err
+		}
+		secondary, serr := h.backend(route.Secondary)
+		if serr != nil {
+			logg.ErrorCtxf(ctx, "shadow backend unavailable", "method", method, "err", serr)
+			return r, err
+		}
+		// the result is the caller's once returned, so it is compared as a copy
+		snap, jerr := json.Marshal(r)
+		if jerr != nil {
+			logg.DebugCtxf(ctx, "not shadowing unencodable result", "method", method, "err", jerr)
+			return r, err
+		}
+		select {
+		case h.shadows <- struct{}{}:
+		default:
+			h.dropped.Add(1)
+			logg.DebugCtxf(ctx, "too many shadow calls, dropping", "method", method)
+			return r, err
+		}
+		go func() {
+			defer func() {
+				<-h.shadows
+			}()
+			shadow(ctx, h, method, route, secondary, snap, err, fn)
+		}()
+	}
+	return r, err
+}
+
+// shadow runs the operation on the secondary backend, and reports whether its
+// outcome differs from that of the primary, whose result is given JSON encoded
+// as snap.
+//
+// The call does not end with the request of ctx, but gets its own timeout.
+func shadow[T any](ctx context.Context, h *AccountService, method string, route Route, svc remote.AccountService, snap []byte, err error, fn func(ctx context.Context, svc remote.AccountService) (T, error)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.shadowTimeout)
+	defer cancel()
+	sr, serr := fn(ctx, svc)
+	ssnap, jerr := json.Marshal(sr)
+	if jerr != nil {
+		logg.DebugCtxf(ctx, "cannot encode shadow result", "method", method, "err", jerr)
+		return
+	}
+	if sameOutcome(snap, err, ssnap, serr) {
+		logg.TraceCtxf(ctx, "shadow backend agrees", "method", method)
+		return
+	}
+	logg.WarnCtxf(ctx, "shadow backend differs", "method", method, "primary", route.Primary, "secondary", route.Secondary, "err", errorKind(err), "shadowErr", errorKind(serr))
+	if h.onDiff == nil {
+		return
+	}
+	diff := Diff{
+		Method:       method,
+		Primary:      route.Primary,
+		Secondary:    route.Secondary,
+		PrimaryErr:   err,
+		SecondaryErr: serr,
+	}
+	if _, ok := any(sr).(struct{}); !ok {
+		var r T
+		json.Unmarshal(snap, &r)
+		diff.PrimaryResult = r
+		diff.SecondaryResult = sr
+	}
+	h.onDiff(ctx, diff)
+}
+
+// sameOutcome reports whether both backends succeeded with results of the same
+// encoding, or both failed with the same kind of error.
+func sameOutcome(snap []byte, err error, ssnap []byte, serr error) bool {
+	if err != nil || serr != nil {
+		if err == nil || serr == nil {
+			return false
+		}
+		return errorKind(err) == errorKind(serr)
+	}
+	return bytes.Equal(snap, ssnap)
+}
+
+// errorKind returns the description of the error kind of err, "" if err is
+// nil, or "unclassified" if it is not of a known kind.
+func errorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	kind := remote.KindOf(err)
+	if kind == nil {
+		return "unclassified"
+	}
+	return kind.Error()
+}
@@ -0,0 +1,196 @@
+package hybrid
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/config"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	remotehttp "git.grassecon.net/grassrootseconomics/sarafu-api/remote/http"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/testutil/testservice"
+)
+
+// failingService fails balance and transfer calls with err, counting them.
+type failingService struct {
+	testservice.TestAccountService
+	err   error
+	calls atomic.Int32
+}
+
+func (fs *failingService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	fs.calls.Add(1)
+	return nil, fs.err
+}
+
+func (fs *failingService) TokenTransfer(ctx context.Context, amount, from, to, tokenAddress string) (*models.TokenTransferResponse, error) {
+	fs.calls.Add(1)
+	return nil, fs.err
+}
+
+func TestHybrid(t *testing.T) {
+	ctx := context.Background()
+	failing := &failingService{
+		err: remote.NewError(remote.ErrInternal, "down"),
+	}
+	var svc remote.AccountService = NewAccountService("old", failing).
+		WithBackend("new", &testservice.TestAccountService{}).
+		WithRoute(remote.MethodCheckBalance, Route{Primary: "new"})
+
+	r, err := svc.CheckBalance(ctx, "0xdeadbeef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != "0.003 CELO" {
+		t.Fatalf("expected balance from routed backend, got %s", r.Balance)
+	}
+	_, err = svc.TokenTransfer(ctx, "1", "0xfrom", "0xto", "0xtoken")
+	if !errors.Is(err, remote.ErrInternal) {
+		t.Fatalf("expected default backend error, got %v", err)
+	}
+
+	// reads fall back on upstream errors, but not on answers
+	h := svc.(*AccountService)
+	h.WithDefaultRoute(Route{Primary: "old", Mode: Fallback, Secondary: "new"})
+	h.WithRoute(remote.MethodCheckBalance, Route{Primary: "old", Mode: Fallback, Secondary: "new"})
+	_, err = h.CheckBalance(ctx, "0xdeadbeef")
+	if err != nil {
+		t.Fatalf("expected fallback, got %v", err)
+	}
+	failing.err = remote.NewError(remote.ErrNotFound, "no such account")
+	_, err = h.CheckBalance(ctx, "0xdeadbeef")
+	if !errors.Is(err, remote.ErrNotFound) {
+		t.Fatalf("expected no fallback on not found, got %v", err)
+	}
+
+	// writes only fall back if the request never reached the primary
+	failing.err = remote.NewError(remote.ErrUpstreamUnavailable, "down")
+	_, err = h.TokenTransfer(ctx, "1", "0xfrom", "0xto", "0xtoken")
+	if !errors.Is(err, remote.ErrUpstreamUnavailable) {
+		t.Fatalf("expected no write fallback on unavailable upstream, got %v", err)
+	}
+	failing.err = &remote.APIError{
+		Kind:   remote.ErrUpstreamUnavailable,
+		Unsent: true,
+	}
+	_, err = h.TokenTransfer(ctx, "1", "0xfrom", "0xto", "0xtoken")
+	if err != nil {
+		t.Fatalf("expected write fallback on unsent request, got %v", err)
+	}
+
+	// shadow returns the primary result, and reports the difference
+	diffs := make(chan Diff, 1)
+	h.WithDiffHandler(func(ctx context.Context, diff Diff) {
+		diffs <- diff
+	})
+	h.WithRoute(remote.MethodCheckBalance, Route{Primary: "new", Mode: Shadow, Secondary: "old"})
+	r, err = h.CheckBalance(ctx, "0xdeadbeef")
+	if err != nil || r.Balance != "0.003 CELO" {
+		t.Fatalf("expected primary result, got %v %v", r, err)
+	}
+	select {
+	case diff := <-diffs:
+		if diff.Method != remote.MethodCheckBalance || diff.PrimaryErr != nil || !errors.Is(diff.SecondaryErr, remote.ErrUpstreamUnavailable) {
+			t.Fatalf("unexpected diff %+v", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected diff")
+	}
+
+	// writes are not shadowed
+	calls := failing.calls.Load()
+	h.WithRoute(remote.MethodTokenTransfer, Route{Primary: "new", Mode: Shadow, Secondary: "old"})
+	_, err = h.TokenTransfer(ctx, "1", "0xfrom", "0xto", "0xtoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if failing.calls.Load() != calls {
+		t.Fatal("write was shadowed")
+	}
+
+	h.WithRoute(remote.MethodCheckBalance, Route{Primary: "missing"})
+	_, err = h.CheckBalance(ctx, "0xdeadbeef")
+	if !errors.Is(err, remote.ErrInternal) {
+		t.Fatalf("expected error on unknown backend, got %v", err)
+	}
+}
+
+// TestHybridWriteFallback checks that writes the upstream may have applied are
+// not sent to the fallback backend.
+func TestHybridWriteFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer srv.Close()
+	cfg := &config.Config{
+		TokenTransferURL: srv.URL,
+	}
+	primary := (&remotehttp.HTTPAccountService{}).WithClient(srv.Client()).WithConfig(cfg).WithCircuitBreaker(remotehttp.NewCircuitBreaker(remotehttp.BreakerPolicy{}))
+	secondary := &failingService{}
+	h := NewAccountService("custodial", primary).
+		WithBackend("other", secondary).
+		WithDefaultRoute(Route{Primary: "custodial", Mode: Fallback, Secondary: "other"})
+
+	_, err := h.TokenTransfer(context.Background(), "1", "0xfrom", "0xto", "0xtoken")
+	if !errors.Is(err, remote.ErrUpstreamUnavailable) {
+		t.Fatalf("expected gateway timeout, got %v", err)
+	}
+	cfg.TokenTransferURL = srv.URL + "/slow"
+	primary.WithTimeout(remote.MethodTokenTransfer, 10*time.Millisecond)
+	_, err = h.TokenTransfer(context.Background(), "1", "0xfrom", "0xto", "0xtoken")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected response timeout, got %v", err)
+	}
+	if secondary.calls.Load() != 0 {
+		t.Fatalf("expected write not to fall back, got %d calls", secondary.calls.Load())
+	}
+
+	srv.Close()
+	cfg.TokenTransferURL = srv.URL
+	_, err = h.TokenTransfer(context.Background(), "1", "0xfrom", "0xto", "0xtoken")
+	if secondary.calls.Load() != 1 {
+		t.Fatalf("expected write to fall back on refused connection, got %d calls", secondary.calls.Load())
+	}
+}
+
+func TestHybridShadowLimit(t *testing.T) {
+	release := make(chan struct{})
+	slow := &blockingService{
+		release: release,
+	}
+	h := NewAccountService("new", &testservice.TestAccountService{}).
+		WithBackend("old", slow).
+		WithDefaultRoute(Route{Primary: "new", Mode: Shadow, Secondary: "old"}).
+		WithMaxShadows(1)
+
+	for i := 0; i < 3; i++ {
+		_, err := h.CheckBalance(context.Background(), "0xdeadbeef")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	if h.ShadowsDropped() != 2 {
+		t.Fatalf("expected 2 dropped shadow calls, got %d", h.ShadowsDropped())
+	}
+}
+
+// blockingService answers balance calls once release is closed.
+type blockingService struct {
+	testservice.TestAccountService
+	release chan struct{}
+}
+
+func (bs *blockingService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	<-bs.release
+	return bs.TestAccountService.CheckBalance(ctx, publicKey)
+}
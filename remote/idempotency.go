@@ -0,0 +1,28 @@
+package remote
+
+import (
+	"context"
+)
+
+type idempotencyKeyCtxKey struct{}
+
+// IdempotencyKeyHeader is the HTTP header carrying the idempotency key of a write request.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// WithIdempotencyKey returns a context carrying the idempotency key to use for
+// the next write operation (TokenTransfer, PoolSwap, PoolDeposit).
+//
+// Repeating a write with the same key returns the result of the original
+// request instead of executing it again.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+// IdempotencyKey returns the idempotency key set on the context, if any.
+func IdempotencyKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyCtxKey{}).(string)
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
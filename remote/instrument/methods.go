@@ -0,0 +1,173 @@
+package instrument
+
+import (
+	"context"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
+)
+
+func (ia *AccountService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	return observe(ia, remote.MethodCheckBalance, func() (*models.BalanceResult, error) {
+		return ia.svc.CheckBalance(ctx, publicKey)
+	})
+}
+
+func (ia *AccountService) CreateAccount(ctx context.Context) (*models.AccountResult, error) {
+	return observe(ia, remote.MethodCreateAccount, func() (*models.AccountResult, error) {
+		return ia.svc.CreateAccount(ctx)
+	})
+}
+
+func (ia *AccountService) TrackAccountStatus(ctx context.Context, publicKey string) (*models.TrackStatusResult, error) {
+	return observe(ia, remote.MethodTrackAccountStatus, func() (*models.TrackStatusResult, error) {
+		return ia.svc.TrackAccountStatus(ctx, publicKey)
+	})
+}
+
+func (ia *AccountService) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	return observe(ia, remote.MethodTrackTransaction, func() (*models.TrackTransactionResult, error) {
+		return ia.svc.TrackTransaction(ctx, trackingId)
+	})
+}
+
+func (ia *AccountService) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
+	return observe(ia, remote.MethodFetchVouchers, func() ([]dataserviceapi.TokenHoldings, error) {
+		return ia.svc.FetchVouchers(ctx, publicKey)
+	})
+}
+
+func (ia *AccountService) FetchTransactions(ctx context.Context, publicKey string) ([]dataserviceapi.Last10TxResponse, error) {
+	return observe(ia, remote.MethodFetchTransactions, func() ([]dataserviceapi.Last10TxResponse, error) {
+		return ia.svc.FetchTransactions(ctx, publicKey)
+	})
+}
+
+func (ia *AccountService) VoucherData(ctx context.Context, address string) (*models.VoucherDataResult, error) {
+	return observe(ia, remote.MethodVoucherData, func() (*models.VoucherDataResult, error) {
+		return ia.svc.VoucherData(ctx, address)
+	})
+}
+
+func (ia *AccountService) TokenTransfer(ctx context.Context, amount, from, to, tokenAddress string) (*models.TokenTransferResponse, error) {
+	return observe(ia, remote.MethodTokenTransfer, func() (*models.TokenTransferResponse, error) {
+		return ia.svc.TokenTransfer(ctx, amount, from, to, tokenAddress)
+	})
+}
+
+func (ia *AccountService) CheckAliasAddress(ctx context.Context, alias string) (*models.AliasAddress, error) {
+	return observe(ia, remote.MethodCheckAliasAddress, func() (*models.AliasAddress, error) {
+		return ia.svc.CheckAliasAddress(ctx, alias)
+	})
+}
+
+func (ia *AccountService) RequestAlias(ctx context.Context, hint string, publicKey string) (*models.RequestAliasResult, error) {
+	return observe(ia, remote.MethodRequestAlias, func() (*models.RequestAliasResult, error) {
+		return ia.svc.RequestAlias(ctx, hint, publicKey)
+	})
+}
+
+func (ia *AccountService) UpdateAlias(ctx context.Context, name string, publicKey string) (*models.RequestAliasResult, error) {
+	return observe(ia, remote.MethodUpdateAlias, func() (*models.RequestAliasResult, error) {
+		return ia.svc.UpdateAlias(ctx, name, publicKey)
+	})
+}
+
+func (ia *AccountService) SendUpsellSMS(ctx context.Context, inviterPhone, inviteePhone string) (*models.SendSMSResponse, error) {
+	return observe(ia, remote.MethodSendUpsellSMS, func() (*models.SendSMSResponse, error) {
+		return ia.svc.SendUpsellSMS(ctx, inviterPhone, inviteePhone)
+	})
+}
+
+func (ia *AccountService) SendAddressSMS(ctx context.Context, publicKey, originPhone string) error {
+	_, err := observe(ia, remote.MethodSendAddressSMS, func() (struct{}, error) {
+		return struct{}{}, ia.svc.SendAddressSMS(ctx, publicKey, originPhone)
+	})
+	return err
+}
+
+func (ia *AccountService) SendPINResetSMS(ctx context.Context, admin, phone string) error {
+	_, err := observe(ia, remote.MethodSendPINResetSMS, func() (struct{}, error) {
+		return struct{}{}, ia.svc.SendPINResetSMS(ctx, admin, phone)
+	})
+	return err
+}
+
+func (ia *AccountService) PoolDeposit(ctx context.Context, amount, from, poolAddress, tokenAddress string) (*models.PoolDepositResult, error) {
+	return observe(ia, remote.MethodPoolDeposit, func() (*models.PoolDepositResult, error) {
+		return ia.svc.PoolDeposit(ctx, amount, from, poolAddress, tokenAddress)
+	})
+}
+
+func (ia *AccountService) FetchTopPools(ctx context.Context) ([]dataserviceapi.PoolDetails, error) {
+	return observe(ia, remote.MethodFetchTopPools, func() ([]dataserviceapi.PoolDetails, error) {
+		return ia.svc.FetchTopPools(ctx)
+	})
+}
+
+func (ia *AccountService) RetrievePoolDetails(ctx context.Context, sym string) (*dataserviceapi.PoolDetails, error) {
+	return observe(ia, remote.MethodRetrievePoolDetails, func() (*dataserviceapi.PoolDetails, error) {
+		return ia.svc.RetrievePoolDetails(ctx, sym)
+	})
+}
+
+func (ia *AccountService) GetPoolSwappableFromVouchers(ctx context.Context, poolAddress, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
+	return observe(ia, remote.MethodGetPoolSwappableFromVouchers, func() ([]dataserviceapi.TokenHoldings, error) {
+		return ia.svc.GetPoolSwappableFromVouchers(ctx, poolAddress, publicKey)
+	})
+}
+
+func (ia *AccountService) GetPoolSwappableVouchers(ctx context.Context, poolAddress string) ([]dataserviceapi.TokenHoldings, error) {
+	return observe(ia, remote.MethodGetPoolSwappableVouchers, func() ([]dataserviceapi.TokenHoldings, error) {
+		return ia.svc.GetPoolSwappableVouchers(ctx, poolAddress)
+	})
+}
+
+func (ia *AccountService) GetPoolSwapQuote(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapQuoteResult, error) {
+	return observe(ia, remote.MethodGetPoolSwapQuote, func() (*models.PoolSwapQuoteResult, error) {
+		return ia.svc.GetPoolSwapQuote(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	})
+}
+
+func (ia *AccountService) PoolSwap(ctx context.Context, amount, from, fromTokenAddress, poolAddress, toTokenAddress string) (*models.PoolSwapResult, error) {
+	return observe(ia, remote.MethodPoolSwap, func() (*models.PoolSwapResult, error) {
+		return ia.svc.PoolSwap(ctx, amount, from, fromTokenAddress, poolAddress, toTokenAddress)
+	})
+}
+
+func (ia *AccountService) GetSwapFromTokenMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.MaxLimitResult, error) {
+	return observe(ia, remote.MethodGetSwapFromTokenMaxLimit, func() (*models.MaxLimitResult, error) {
+		return ia.svc.GetSwapFromTokenMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	})
+}
+
+func (ia *AccountService) CheckTokenInPool(ctx context.Context, poolAddress, tokenAddress string) (*models.TokenInPoolResult, error) {
+	return observe(ia, remote.MethodCheckTokenInPool, func() (*models.TokenInPoolResult, error) {
+		return ia.svc.CheckTokenInPool(ctx, poolAddress, tokenAddress)
+	})
+}
+
+func (ia *AccountService) GetCreditSendMaxLimit(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, publicKey string) (*models.CreditSendLimitsResult, error) {
+	return observe(ia, remote.MethodGetCreditSendMaxLimit, func() (*models.CreditSendLimitsResult, error) {
+		return ia.svc.GetCreditSendMaxLimit(ctx, poolAddress, fromTokenAddress, toTokenAddress, publicKey)
+	})
+}
+
+func (ia *AccountService) GetCreditSendReverseQuote(ctx context.Context, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount string) (*models.CreditSendReverseQouteResult, error) {
+	return observe(ia, remote.MethodGetCreditSendReverseQuote, func() (*models.CreditSendReverseQouteResult, error) {
+		return ia.svc.GetCreditSendReverseQuote(ctx, poolAddress, fromTokenAddress, toTokenAddress, toTokenAMount)
+	})
+}
+
+func (ia *AccountService) MpesaTriggerOnramp(ctx context.Context, address, phoneNumber, asset string, amount int) (*models.MpesaOnrampResponse, error) {
+	return observe(ia, remote.MethodMpesaTriggerOnramp, func() (*models.MpesaOnrampResponse, error) {
+		return ia.svc.MpesaTriggerOnramp(ctx, address, phoneNumber, asset, amount)
+	})
+}
+
+func (ia *AccountService) GetMpesaOnrampRates(ctx context.Context) (*models.MpesaOnrampRatesResponse, error) {
+	return observe(ia, remote.MethodGetMpesaOnrampRates, func() (*models.MpesaOnrampRatesResponse, error) {
+		return ia.svc.GetMpesaOnrampRates(ctx)
+	})
+}
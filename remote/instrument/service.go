@@ -0,0 +1,147 @@
+// Package instrument provides an AccountService recording metrics of the calls
+// made to another AccountService.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/metrics"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+)
+
+// Names of the metrics recorded by AccountService.
+const (
+	CallsMetric    = "sarafu_api_calls_total"
+	ErrorsMetric   = "sarafu_api_call_errors_total"
+	DurationMetric = "sarafu_api_call_duration_seconds"
+)
+
+// kindLabels holds the values of the kind label of the error kinds of the
+// remote package.
+var kindLabels = map[error]string{
+	remote.ErrNotFound:            "not_found",
+	remote.ErrInsufficientBalance: "insufficient_balance",
+	remote.ErrInvalidAlias:        "invalid_alias",
+	remote.ErrUpstreamUnavailable: "upstream_unavailable",
+	remote.ErrUnauthorized:        "unauthorized",
+	remote.ErrRateLimited:         "rate_limited",
+	remote.ErrValidation:          "validation",
+	remote.ErrInternal:            "internal",
+	remote.ErrBadResponse:         "bad_response",
+}
+
+// upstreams holds the name of the upstream serving each operation, as named by
+// config.Config.Bases.
+var upstreams = map[string]string{
+	remote.MethodCreateAccount:                "custodial",
+	remote.MethodTrackAccountStatus:           "custodial",
+	remote.MethodTrackTransaction:             "custodial",
+	remote.MethodCheckBalance:                 "custodial",
+	remote.MethodTokenTransfer:                "custodial",
+	remote.MethodPoolDeposit:                  "custodial",
+	remote.MethodGetPoolSwapQuote:             "custodial",
+	remote.MethodPoolSwap:                     "custodial",
+	remote.MethodFetchVouchers:                "data",
+	remote.MethodFetchTransactions:            "data",
+	remote.MethodVoucherData:                  "data",
+	remote.MethodSendUpsellSMS:                "data",
+	remote.MethodFetchTopPools:                "data",
+	remote.MethodRetrievePoolDetails:          "data",
+	remote.MethodGetPoolSwappableFromVouchers: "data",
+	remote.MethodGetPoolSwappableVouchers:     "data",
+	remote.MethodGetSwapFromTokenMaxLimit:     "data",
+	remote.MethodCheckTokenInPool:             "data",
+	remote.MethodGetCreditSendMaxLimit:        "data",
+	remote.MethodGetCreditSendReverseQuote:    "data",
+	remote.MethodCheckAliasAddress:            "alias",
+	remote.MethodRequestAlias:                 "alias",
+	remote.MethodUpdateAlias:                  "alias",
+	remote.MethodSendAddressSMS:               "external_sms",
+	remote.MethodSendPINResetSMS:              "external_sms",
+	remote.MethodMpesaTriggerOnramp:           "mpesa_onramp",
+	remote.MethodGetMpesaOnrampRates:          "mpesa_onramp",
+}
+
+// AccountService is a remote.AccountService counting the calls made to
+// another AccountService, their errors by kind and code, and their duration,
+// by operation and upstream.
+type AccountService struct {
+	svc      remote.AccountService
+	upstream string
+	calls    *metrics.Counter
+	errors   *metrics.Counter
+	duration *metrics.Histogram
+}
+
+// NewAccountService creates an AccountService recording the calls to svc in
+// metrics.Default, labelled with the upstream serving each operation.
+func NewAccountService(svc remote.AccountService) *AccountService {
+	ia := &AccountService{
+		svc: svc,
+	}
+	return ia.WithRegistry(metrics.Default)
+}
+
+// WithUpstream labels the calls of all operations with the given upstream,
+// for services such as the dev service that are not backed by the upstreams.
+func (ia *AccountService) WithUpstream(upstream string) *AccountService {
+	ia.upstream = upstream
+	return ia
+}
+
+// upstreamOf returns the upstream label of the operation.
+func (ia *AccountService) upstreamOf(method string) string {
+	if ia.upstream != "" {
+		return ia.upstream
+	}
+	upstream, ok := upstreams[method]
+	if !ok {
+		return "unknown"
+	}
+	return upstream
+}
+
+// WithRegistry sets the registry the metrics are recorded in.
+func (ia *AccountService) WithRegistry(reg *metrics.Registry) *AccountService {
+	ia.calls = reg.Counter(CallsMetric, "Calls of AccountService operations.", "method", "upstream")
+	ia.errors = reg.Counter(ErrorsMetric, "Failed calls of AccountService operations, by error kind and upstream error code.", "method", "upstream", "kind", "code")
+	ia.duration = reg.Histogram(DurationMetric, "Duration of calls of AccountService operations.", nil, "method", "upstream")
+	return ia
+}
+
+// errorLabels returns the kind and code labels of err.
+func errorLabels(err error) (string, string) {
+	var code string
+
+	var apiErr *remote.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.Code
+	}
+	kind, ok := kindLabels[remote.KindOf(err)]
+	if ok {
+		return kind, code
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled", code
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded", code
+	}
+	return "unclassified", code
+}
+
+// observe calls fn and records the outcome for the operation.
+func observe[T any](ia *AccountService, method string, fn func() (T, error)) (T, error) {
+	upstream := ia.upstreamOf(method)
+	start := time.Now()
+	r, err := fn()
+	ia.duration.Observe(time.Since(start).Seconds(), method, upstream)
+	ia.calls.Inc(method, upstream)
+	if err != nil {
+		kind, code := errorLabels(err)
+		ia.errors.Inc(method, upstream, kind, code)
+	}
+	return r, err
+}
@@ -0,0 +1,68 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"git.grassecon.net/grassrootseconomics/sarafu-api/metrics"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/models"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/remote"
+	"git.grassecon.net/grassrootseconomics/sarafu-api/testutil/testservice"
+)
+
+// failingService fails balance calls with an upstream error code.
+type failingService struct {
+	testservice.TestAccountService
+}
+
+func (fs *failingService) CheckBalance(ctx context.Context, publicKey string) (*models.BalanceResult, error) {
+	return nil, &remote.APIError{
+		Kind: remote.ErrNotFound,
+		Code: "E05",
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+	svc := NewAccountService(&failingService{}).WithRegistry(reg)
+
+	_, err := svc.CreateAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.CheckBalance(ctx, "0xdeadbeef")
+	if !errors.Is(err, remote.ErrNotFound) {
+		t.Fatalf("expected error to be passed on, got %v", err)
+	}
+
+	calls := reg.Counter(CallsMetric, "", "method", "upstream")
+	if calls.Value(remote.MethodCreateAccount, "custodial") != 1 || calls.Value(remote.MethodCheckBalance, "custodial") != 1 {
+		t.Fatal("expected one call of each operation counted")
+	}
+	errs := reg.Counter(ErrorsMetric, "", "method", "upstream", "kind", "code")
+	if errs.Value(remote.MethodCheckBalance, "custodial", "not_found", "E05") != 1 {
+		t.Fatal("expected failed call counted by kind and code")
+	}
+	if errs.Value(remote.MethodCreateAccount, "custodial", "unclassified", "") != 0 {
+		t.Fatal("expected successful call not counted as error")
+	}
+	// calls are labelled with the upstream of their operation
+	_, err = svc.CheckAliasAddress(ctx, "alice.sarafu.eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Value(remote.MethodCheckAliasAddress, "alias") != 1 {
+		t.Fatal("expected alias call counted for the alias upstream")
+	}
+	svc.WithUpstream("dev")
+	svc.CheckAliasAddress(ctx, "alice.sarafu.eth")
+	if calls.Value(remote.MethodCheckAliasAddress, "dev") != 1 {
+		t.Fatal("expected call counted for the set upstream")
+	}
+	duration := reg.Histogram(DurationMetric, "", nil, "method", "upstream")
+	if duration.Count(remote.MethodCheckBalance, "custodial") != 1 {
+		t.Fatal("expected call duration observed")
+	}
+}
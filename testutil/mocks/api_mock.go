@@ -36,6 +36,10 @@ func (m MockApi) TrackAccountStatus(ctx context.Context, publicKey string) (*mod
 	return nil, nil
 }
 
+func (m MockApi) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	return nil, nil
+}
+
 func (m MockApi) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
 	logg.DebugCtxf(ctx, "mockapi fetchvouchers", "key", publicKey)
 	return m.VouchersContent, nil
@@ -28,6 +28,11 @@ func (m *MockAccountService) TrackAccountStatus(ctx context.Context, trackingId
 	return args.Get(0).(*models.TrackStatusResult), args.Error(1)
 }
 
+func (m *MockAccountService) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	args := m.Called(trackingId)
+	return args.Get(0).(*models.TrackTransactionResult), args.Error(1)
+}
+
 func (m *MockAccountService) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
 	args := m.Called(publicKey)
 	return args.Get(0).([]dataserviceapi.TokenHoldings), args.Error(1)
@@ -34,6 +34,12 @@ func (tas *TestAccountService) TrackAccountStatus(ctx context.Context, publicKey
 	}, nil
 }
 
+func (tas *TestAccountService) TrackTransaction(ctx context.Context, trackingId string) (*models.TrackTransactionResult, error) {
+	return &models.TrackTransactionResult{
+		Status: models.TxStatusConfirmed,
+	}, nil
+}
+
 func (tas *TestAccountService) FetchVouchers(ctx context.Context, publicKey string) ([]dataserviceapi.TokenHoldings, error) {
 	return []dataserviceapi.TokenHoldings{
 		{